@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+	"github.com/txlog/agent/util"
+)
+
+// errBatchUnsupported is returned when the server has no endpoint for
+// batches of transactions, and they must be sent one at a time.
+var errBatchUnsupported = errors.New("server does not support batches of transactions")
+
+// defaultUploadBatchSize is used when the server.batch_size setting is not set.
+const defaultUploadBatchSize = 50
+
+// maxUploadBatchSize caps the number of transactions sent in a single request.
+const maxUploadBatchSize = 500
+
+// serverVersionAtLeast reports whether serverVersion, as returned by
+// GetServerVersion, is minVersion or newer. An unknown or invalid version is
+// considered older than any version.
+func serverVersionAtLeast(serverVersion, minVersion string) bool {
+	if serverVersion == "unknown" {
+		return false
+	}
+
+	sv, err := semver.NewVersion(serverVersion)
+	if err != nil {
+		return false
+	}
+
+	return !sv.LessThan(semver.MustParse(minVersion))
+}
+
+// endpointUnsupported reports whether a status code means that the server
+// has no such endpoint or method, as is the case of a server older than the
+// feature using it.
+func endpointUnsupported(statusCode int) bool {
+	return statusCode == 404 || statusCode == 405
+}
+
+// uploadBatchSize returns the number of transactions sent per request. It is
+// 1, meaning one POST per transaction, when server.batch_size is set to 1 or
+// less. Servers without batches are detected on the first batch sent, see
+// errBatchUnsupported.
+func uploadBatchSize() int {
+	size := defaultUploadBatchSize
+	if viper.IsSet("server.batch_size") {
+		size = viper.GetInt("server.batch_size")
+	}
+
+	return min(max(size, 1), maxUploadBatchSize)
+}
+
+// postTransactionBatch sends transactions to the server in a single request,
+// as a gzip-compressed JSON array of their encodings, which must all fit in a
+// single request. The server saves either all of them or none. A compressed
+// batch larger than maxSize bytes is not sent, and an error is returned
+// instead; maxSize 0 sends batches of any size. It returns a
+// ServerUnavailableError when the server could not be reached, and
+// errBatchUnsupported when it does not accept batches.
+func postTransactionBatch(client *resty.Client, transactions []*encodedTransaction, maxSize int) error {
+	bodies := make([]map[string]interface{}, len(transactions))
+	encoded := make([]json.RawMessage, len(transactions))
+	for i, transaction := range transactions {
+		bodies[i] = transaction.body
+		encoded[i] = transaction.data
+	}
+
+	compressed, err := gzipJSON(encoded)
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && len(compressed) > maxSize {
+		return fmt.Errorf("batch of %d bytes exceeds the maximum body size of %d bytes", len(compressed), maxSize)
+	}
+
+	request := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Content-Encoding", "gzip").
+		SetHeader(idempotencyKeyHeader, batchIdempotencyKey(bodies)).
+		SetBody(compressed)
+
+	util.SetAuthentication(request)
+
+	response, err := request.Post(viper.GetString("server.url") + "/v1/transactions/batch")
+
+	if err != nil {
+		return &ServerUnavailableError{Err: err}
+	}
+
+	if endpointUnsupported(response.StatusCode()) {
+		return errBatchUnsupported
+	}
+
+	if response.StatusCode() != 200 {
+		err := fmt.Errorf("server returned status code %d", response.StatusCode())
+		if serverUnavailable(response, nil) {
+			return &ServerUnavailableError{Err: err}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// gzipJSON returns the gzip-compressed JSON encoding of v.
+func gzipJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writer := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(writer).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
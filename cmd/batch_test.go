@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestServerVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected bool
+	}{
+		{"1.8.0", true},
+		{"1.20.3", true},
+		{"1.7.9", false},
+		{"unknown", false},
+		{"invalid", false},
+	}
+
+	for _, tt := range tests {
+		if got := serverVersionAtLeast(tt.version, "1.8.0"); got != tt.expected {
+			t.Errorf("serverVersionAtLeast(%q, 1.8.0) = %v, want %v", tt.version, got, tt.expected)
+		}
+	}
+}
+
+func TestUploadBatchSize(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	if size := uploadBatchSize(); size != defaultUploadBatchSize {
+		t.Errorf("uploadBatchSize() = %d, want the default %d", size, defaultUploadBatchSize)
+	}
+
+	viper.Set("server.batch_size", 10)
+	if size := uploadBatchSize(); size != 10 {
+		t.Errorf("uploadBatchSize() = %d, want 10 from the configuration", size)
+	}
+
+	viper.Set("server.batch_size", 0)
+	if size := uploadBatchSize(); size != 1 {
+		t.Errorf("uploadBatchSize() = %d, want 1 when batches are disabled", size)
+	}
+
+	viper.Set("server.batch_size", 100000)
+	if size := uploadBatchSize(); size != maxUploadBatchSize {
+		t.Errorf("uploadBatchSize() = %d, want at most %d", size, maxUploadBatchSize)
+	}
+}
+
+func TestSaveUnsentTransactions_Batches(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	var single []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/v1/transactions/batch":
+			if r.Header.Get("Content-Encoding") != "gzip" {
+				t.Errorf("batch sent without Content-Encoding: gzip")
+			}
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("batch body is not gzip-compressed: %v", err)
+				return
+			}
+			var bodies []map[string]interface{}
+			if err := json.NewDecoder(reader).Decode(&bodies); err != nil {
+				t.Errorf("batch body is not a JSON array: %v", err)
+				return
+			}
+
+			var ids []string
+			for _, body := range bodies {
+				ids = append(ids, body["transaction_id"].(string))
+			}
+			batches = append(batches, ids)
+
+			// The batch holding transaction 5 is rejected
+			for _, id := range ids {
+				if id == "5" {
+					w.WriteHeader(http.StatusUnprocessableEntity)
+				}
+			}
+		case "/v1/transactions":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			single = append(single, body["transaction_id"].(string))
+			if body["transaction_id"] == "6" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+			}
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	source := &stubHistorySource{}
+	for i := 1; i <= 10; i++ {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: strconv.Itoa(i)})
+	}
+
+	processed, sent, failures, err := saveUnsentTransactions(source, nil, "machine", "host", map[string]struct{}{}, 2, 4, 0)
+	if err != nil {
+		t.Fatalf("saveUnsentTransactions() error = %v", err)
+	}
+	if processed != 10 || sent != 9 {
+		t.Errorf("saveUnsentTransactions() = %d processed, %d sent; want 10 and 9", processed, sent)
+	}
+	if len(failures) != 1 || failures[0].TransactionID != "6" {
+		t.Errorf("failures = %v, want transaction 6, rejected after its batch", failures)
+	}
+
+	sort.Slice(batches, func(i, j int) bool { return batches[i][0] < batches[j][0] })
+	if len(batches) != 3 || len(batches[0]) != 4 || len(batches[2]) != 2 {
+		t.Errorf("batches = %v, want 2 of 4 transactions and 1 of 2", batches)
+	}
+
+	sort.Strings(single)
+	want := []string{"5", "6", "7", "8"}
+	if len(single) != len(want) {
+		t.Fatalf("transactions sent one at a time = %v, want %v", single, want)
+	}
+	for i := range want {
+		if single[i] != want[i] {
+			t.Errorf("transactions sent one at a time = %v, want %v", single, want)
+			break
+		}
+	}
+}
+
+func TestSaveUnsentTransactions_BatchesUnsupported(t *testing.T) {
+	var mu sync.Mutex
+	batches := 0
+	var single []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/v1/transactions/batch":
+			batches++
+			w.WriteHeader(http.StatusNotFound)
+		case "/v1/transactions":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			single = append(single, body["transaction_id"].(string))
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	source := &stubHistorySource{}
+	for i := 1; i <= 12; i++ {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: strconv.Itoa(i)})
+	}
+
+	processed, sent, failures, err := saveUnsentTransactions(source, nil, "machine", "host", map[string]struct{}{}, 1, 4, 0)
+	if err != nil {
+		t.Fatalf("saveUnsentTransactions() error = %v", err)
+	}
+	if processed != 12 || sent != 12 || len(failures) != 0 {
+		t.Errorf("saveUnsentTransactions() = %d processed, %d sent, %v failed; want 12, 12 and none", processed, sent, failures)
+	}
+
+	// Once the server is found not to accept batches, no other one is sent
+	if batches != 1 || len(single) != 12 {
+		t.Errorf("server received %d batches and %d single transactions, want 1 and 12", batches, len(single))
+	}
+}
@@ -1,35 +1,43 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/Masterminds/semver/v3"
 	"github.com/fatih/color"
 	"github.com/go-resty/resty/v2"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/txlog/agent/internal/spool"
 	"github.com/txlog/agent/util"
 )
 
 // TransactionDetail represents a detailed transaction entry, as shown in the 'dnf history info' command.
 type TransactionDetail struct {
-	TransactionID   string    `json:"transaction_id"`
-	BeginTime       string    `json:"begin_time"`
-	BeginRPMDB      string    `json:"begin_rpmdb"`
-	EndTime         string    `json:"end_time"`
-	EndRPMDB        string    `json:"end_rpmdb"`
-	User            string    `json:"user"`
-	ReturnCode      string    `json:"return_code"`
-	Releasever      string    `json:"releasever"`
-	CommandLine     string    `json:"command_line"`
-	Comment         string    `json:"comment"`
-	PackagesAltered []Package `json:"packages_altered"`
-	ScriptletOutput []string  `json:"scriptlet_output"`
+	TransactionID   string          `json:"transaction_id"`
+	BeginTime       string          `json:"begin_time"`
+	BeginRPMDB      string          `json:"begin_rpmdb"`
+	EndTime         string          `json:"end_time"`
+	EndRPMDB        string          `json:"end_rpmdb"`
+	User            string          `json:"user"`
+	ReturnCode      string          `json:"return_code"`
+	Status          string          `json:"status"`
+	ExitCode        int             `json:"exit_code"`
+	ErrorMessage    string          `json:"error_message"`
+	Releasever      string          `json:"releasever"`
+	CommandLine     string          `json:"command_line"`
+	Comment         string          `json:"comment"`
+	PackagesAltered []Package       `json:"packages_altered"`
+	PackageChanges  []PackageChange `json:"package_changes"`
+	ScriptletOutput []string        `json:"scriptlet_output"`
+	Scriptlets      []ScriptletLine `json:"scriptlets"`
+	Errors          []string        `json:"errors"`
 }
 
 // Package represents a single package in a transaction entry.
@@ -42,17 +50,70 @@ type Package struct {
 	Arch     string `json:"arch"`
 	Repo     string `json:"repo"`
 	FromRepo string `json:"from_repo,omitempty"`
+	Reason   string `json:"reason,omitempty"`
 }
 
-// Pre-compiled regexes for parsing DNF history output
-var (
-	reHistoryLine      = regexp.MustCompile(`\s*(\d+)\s*\|\s*(.+?)\s*\|\s*(.+?)\s*\|\s*(.+?)\s*\|\s*(.+?)\s*`)
-	reValidInput       = regexp.MustCompile(`^[0-9]+$`)
-	reTransactionField = regexp.MustCompile(`^(.+?)\s*:\s*(.+)$`)
-	rePackageInstall   = regexp.MustCompile(`^\s+(\w+)\s+(.+?)\s+@(.+)$`)
-	rePackageUpgraded  = regexp.MustCompile(`^\s+(\w+)\s+(.+?)\s+@@(.+)$`)
+// Reasons a package was touched by a transaction, normalized across history
+// sources. An empty reason means the source did not record it.
+const (
+	reasonUser           = "user"
+	reasonDependency     = "dependency"
+	reasonWeakDependency = "weak-dependency"
+	reasonClean          = "clean"
+	reasonGroup          = "group"
 )
 
+// ServerUnavailableError is returned when the server could not be reached or
+// was temporarily unavailable, so that the request is worth retrying later.
+type ServerUnavailableError struct {
+	Err error
+}
+
+// Error implements error.
+func (e *ServerUnavailableError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ServerUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// ServerStatusError is returned when the server answered a request with a
+// status code other than 200.
+type ServerStatusError struct {
+	StatusCode int
+}
+
+// Error implements error.
+func (e *ServerStatusError) Error() string {
+	return fmt.Sprintf("server returned status code %d", e.StatusCode)
+}
+
+// exitPartialFailure is the exit code of a build that sent some transactions
+// but failed to read or send others, as opposed to 1 for a build that could
+// not run at all, such as when the server is unreachable.
+const exitPartialFailure = 2
+
+// transactionFailure records why a transaction could not be sent.
+type transactionFailure struct {
+	TransactionID string
+	Err           error
+}
+
+// failuresSummary describes the failed transactions for the execution report.
+func failuresSummary(failures []transactionFailure) string {
+	lines := make([]string, 0, len(failures)+1)
+	lines = append(lines, fmt.Sprintf("%d transactions failed", len(failures)))
+	for _, failure := range failures {
+		lines = append(lines, fmt.Sprintf("#%s: %v", failure.TransactionID, failure.Err))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reValidInput validates transaction IDs before they are used in commands or queries
+var reValidInput = regexp.MustCompile(`^[0-9]+$`)
+
 // buildCmd represents the build command
 var buildCmd = &cobra.Command{
 	Use:   "build",
@@ -61,6 +122,18 @@ var buildCmd = &cobra.Command{
 This command compiles all transactions listed on 'dnf history'
 command, and sends them to the server so they can be queried later.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		// A dry run sends nothing to the server and writes nothing locally
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		showPayloads, _ := cmd.Flags().GetBool("payloads")
+		if showPayloads && !dryRun {
+			color.Red("✗ Error: --payloads requires --dry-run")
+			os.Exit(1)
+		}
+		if showPayloads {
+			// * keeps stdout for the payloads, one JSON body per line
+			color.Output = os.Stderr
+		}
+
 		machineId, err := util.GetMachineId()
 		if err != nil {
 			color.Red("✗ Error getting machine ID: %v", err)
@@ -71,54 +144,180 @@ command, and sends them to the server so they can be queried later.`,
 			color.Red("✗ Error getting hostname: %v", err)
 			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stdout, "🔍 Compiling host identification for %s\n", color.CyanString(hostname))
-		fmt.Fprintf(os.Stdout, "   Machine ID: %s\n\n", color.CyanString(machineId))
+		fmt.Fprintf(color.Output, "🔍 Compiling host identification for %s\n", color.CyanString(hostname))
+		fmt.Fprintf(color.Output, "   Machine ID: %s\n\n", color.CyanString(machineId))
+
+		workers, err := concurrency(cmd)
+		if err != nil {
+			color.Red("✗ Error: %v", err)
+			os.Exit(1)
+		}
+
+		source, err := openHistorySource(historySourceSpec(cmd))
+		if err != nil {
+			color.Red("✗ Error opening transaction history: %v", err)
+			if dryRun {
+				os.Exit(1)
+			}
+			if execErr := saveExecution(false, machineId, hostname, err.Error(), 0, 0, 0); execErr != nil {
+				color.Yellow("⚠ Warning: failed to save execution report: %v", execErr)
+			}
+			os.Exit(1)
+		}
+		defer source.Close()
+
+		var sp *spool.Spool
+		if !dryRun {
+			sp = openSpool()
+		}
+
+		// * skips the transactions when the local history did not change since the last build that left the server in sync
+		//    * The execution report is still sent, so that the server keeps the heartbeat and restart status of the host
+		full, _ := cmd.Flags().GetBool("full")
+		statePath := stateFile()
+		if dryRun {
+			full = true
+		}
+		var current syncState
+		currentErr := errors.New("sync state disabled")
+		if statePath != "" {
+			current, currentErr = currentSyncState(source, machineId)
+			if currentErr == nil && !full && spoolEmpty(sp) {
+				saved, err := loadSyncState(statePath)
+				if err != nil {
+					color.Yellow("⚠ Warning: ignoring sync state: %v", err)
+				} else if saved.matches(current) {
+					color.Cyan("ℹ No new transactions since the last build (%s), nothing to send", saved.SyncedAt.Local().Format(time.DateTime))
+					fmt.Fprintf(os.Stdout, "   Run with --full to check the server anyway\n\n")
+					if execErr := saveExecution(true, machineId, hostname, "no new transactions since the last build", 0, 0, 0); execErr != nil {
+						color.Yellow("⚠ Warning: failed to save execution report: %v", execErr)
+					}
+					return
+				}
+			}
+		}
 
 		// * retrieves a list of all transactions saved on the server for this `machine-id`
-		fmt.Fprintf(os.Stdout, "📥 Retrieving saved transactions...\n")
+		fmt.Fprintf(color.Output, "📥 Retrieving saved transactions...\n")
 		savedTransactions, savedCount, err := getSavedTransactions(machineId, hostname)
 		if err != nil {
 			color.Red("✗ Error retrieving saved transactions: %v", err)
-			if execErr := saveExecution(false, machineId, hostname, err.Error(), 0, 0); execErr != nil {
+			var unavailable *ServerUnavailableError
+			if sp != nil && errors.As(err, &unavailable) {
+				fmt.Fprintf(os.Stdout, "\n📦 Spooling unsent transactions to %s...\n", sp.Dir())
+				spooled, spoolErr := spoolUnsentTransactions(sp, source, machineId, hostname)
+				if spoolErr != nil {
+					color.Red("✗ Error spooling transactions: %v", spoolErr)
+				}
+				fmt.Fprintf(os.Stdout, "   %s transactions spooled\n", color.YellowString("%d", spooled))
+			}
+			if dryRun {
+				os.Exit(1)
+			}
+			if execErr := saveExecution(false, machineId, hostname, err.Error(), 0, 0, 0); execErr != nil {
 				color.Yellow("⚠ Warning: failed to save execution report: %v", execErr)
 			}
 			os.Exit(1)
 		}
-		fmt.Fprintf(os.Stdout, "   Found %s saved transactions on server\n\n", color.YellowString("%d", savedCount))
+		fmt.Fprintf(color.Output, "   Found %s saved transactions on server\n\n", color.YellowString("%d", savedCount))
+
+		savedSet := make(map[string]struct{}, len(savedTransactions))
+		for _, t := range savedTransactions {
+			savedSet[fmt.Sprintf("%d", t)] = struct{}{}
+		}
+
+		if dryRun {
+			var payloads io.Writer
+			if showPayloads {
+				payloads = os.Stdout
+			}
+			os.Exit(dryRunBuild(source, machineId, hostname, savedSet, workers, payloads))
+		}
+
+		if sp != nil {
+			if err := writeLastSavedID(sp, savedTransactions); err != nil {
+				color.Yellow("⚠ Warning: failed to update spool: %v", err)
+			}
+
+			// * delivers the payloads spooled while the server was unavailable, before any new one
+			if stats, err := sp.Stats(); err == nil && stats.Count > 0 {
+				fmt.Fprintf(os.Stdout, "📤 Replaying %s spooled payloads...\n", color.YellowString("%d", stats.Count))
+				delivered, dropped, err := replaySpool(sp, savedSet, uploadMaxBodySize())
+				if err != nil {
+					color.Red("✗ Error replaying spooled payloads: %v", err)
+					if execErr := saveExecution(false, machineId, hostname, err.Error(), 0, 0, 0); execErr != nil {
+						color.Yellow("⚠ Warning: failed to save execution report: %v", execErr)
+					}
+					os.Exit(1)
+				}
+				fmt.Fprintf(os.Stdout, "   %s delivered, %s already on server\n\n", color.GreenString("%d", delivered), color.CyanString("%d", dropped))
+			}
+		}
 
 		fmt.Fprintf(os.Stdout, "⚙️  Compiling transaction data...\n")
 		// * compares the transaction lists to determine which transactions have not been sent to the server
-		// * sends the unsent transactions to the server, one at a time, with data extracted from `sudo dnf history info ID`
+		// * sends the unsent transactions to the server, with data extracted from `sudo dnf history info ID`
 		//    * The sending of the transaction and its details needs to be atomic
-		entriesProcessed, entriesSent, err := saveUnsentTransactions(machineId, hostname, savedTransactions)
+		//    * Up to `--concurrency` transactions are read and sent at the same time
+		//    * Servers that support it receive the transactions in compressed batches
+		//    * Transactions that cannot be sent because the server is unavailable are spooled
+		//    * A transaction that cannot be read or is rejected by the server does not stop the others
+		//    * Transactions larger than the maximum body size are sent in chunks
+		entriesProcessed, entriesSent, failures, err := saveUnsentTransactions(source, sp, machineId, hostname, savedSet, workers, uploadBatchSize(), uploadMaxBodySize())
 		if err != nil {
 			color.Red("✗ Error retrieving transactions: %v", err)
-			if execErr := saveExecution(false, machineId, hostname, err.Error(), 0, 0); execErr != nil {
+			if execErr := saveExecution(false, machineId, hostname, err.Error(), entriesProcessed, entriesSent, len(failures)); execErr != nil {
 				color.Yellow("⚠ Warning: failed to save execution report: %v", execErr)
 			}
 			os.Exit(1)
 		}
 
-		if execErr := saveExecution(true, machineId, hostname, "", entriesProcessed, entriesSent); execErr != nil {
+		details := ""
+		if len(failures) > 0 {
+			details = failuresSummary(failures)
+		} else if currentErr == nil {
+			current.SyncedAt = time.Now()
+			if err := saveSyncState(statePath, current); err != nil {
+				color.Yellow("⚠ Warning: failed to save sync state: %v", err)
+			}
+		}
+		if execErr := saveExecution(len(failures) == 0, machineId, hostname, details, entriesProcessed, entriesSent, len(failures)); execErr != nil {
 			color.Yellow("⚠ Warning: failed to save execution report: %v", execErr)
 		}
 
 		fmt.Fprintln(os.Stdout)
 		fmt.Fprintln(os.Stdout, strings.Repeat("=", 60))
-		if entriesSent > 0 {
+		if len(failures) > 0 {
+			color.Yellow("⚠ Build completed with %d failed transactions", len(failures))
+		} else if entriesSent > 0 {
 			color.Green("✓ Build completed successfully!")
 		} else {
 			color.Cyan("ℹ Build completed - all transactions already synced")
 		}
 		fmt.Fprintf(os.Stdout, "   Transactions processed: %s\n", color.CyanString("%d", entriesProcessed))
 		fmt.Fprintf(os.Stdout, "   Transactions sent:      %s\n", color.GreenString("%d", entriesSent))
+		if len(failures) > 0 {
+			fmt.Fprintf(os.Stdout, "   Transactions failed:    %s\n", color.RedString("%d", len(failures)))
+			for _, failure := range failures {
+				fmt.Fprintf(os.Stdout, "     #%s: %v\n", failure.TransactionID, failure.Err)
+			}
+		}
 		fmt.Fprintln(os.Stdout, strings.Repeat("=", 60))
 		fmt.Fprintln(os.Stdout)
 
+		if len(failures) > 0 {
+			os.Exit(exitPartialFailure)
+		}
+
 	},
 }
 
 func init() {
+	addHistorySourceFlag(buildCmd)
+	addConcurrencyFlag(buildCmd, "number of transactions read and sent at the same time")
+	buildCmd.Flags().Bool("full", false, "check the server even when the local history did not change since the last build")
+	buildCmd.Flags().Bool("dry-run", false, "read the history and compare it with the server, but print what would be sent instead of sending it")
+	buildCmd.Flags().Bool("payloads", false, "with --dry-run, print the JSON body of each transaction and of the execution report to stdout, one per line")
 	rootCmd.AddCommand(buildCmd)
 }
 
@@ -133,10 +332,10 @@ func init() {
 //   - []int: slice of transaction IDs retrieved from the server
 //   - int: number of transactions retrieved
 //   - error: nil if successful, otherwise contains error information
-//     Possible errors include network failures or non-200 HTTP status codes
+//     Possible errors include network failures or non-200 HTTP status codes;
+//     a ServerUnavailableError is returned when the server could not be reached
 func getSavedTransactions(machineId, hostname string) ([]int, int, error) {
-	client := resty.New()
-	client.SetTimeout(30 * time.Second)
+	client := util.NewServerClient()
 
 	var transactions []int
 	request := client.R().
@@ -152,201 +351,354 @@ func getSavedTransactions(machineId, hostname string) ([]int, int, error) {
 	response, err := request.Get(viper.GetString("server.url") + "/v1/transactions/ids")
 
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, &ServerUnavailableError{Err: err}
 	}
 
 	if response.StatusCode() != 200 {
-		return nil, 0, fmt.Errorf("server returned status code %d: %s", response.StatusCode(), response.String())
+		err := fmt.Errorf("server returned status code %d: %s", response.StatusCode(), response.String())
+		if serverUnavailable(response, nil) {
+			err = &ServerUnavailableError{Err: err}
+		}
+		return nil, 0, err
 	}
 
 	return transactions, len(transactions), nil
 }
 
-// saveUnsentTransactions processes DNF transaction history and sends unsent transactions to a remote server.
-// It takes the machine ID, hostname, and a slice of previously saved transaction IDs as input.
+// saveUnsentTransactions processes the local transaction history and sends unsent transactions to a remote server.
+// It takes the history source, the spool, machine ID, hostname, the set of previously saved transaction IDs,
+// the number of workers and the number of transactions per request as input.
 //
 // The function performs the following steps:
-// 1. Retrieves the DNF transaction history in reverse order
-// 2. Parses each transaction entry
-// 3. For transactions not previously saved:
-//   - Gets detailed transaction information
-//   - Sends the transaction data to the configured server endpoint
+// 1. Retrieves the local transaction history in ascending order
+// 2. Selects the transactions not previously saved
+// 3. For each chunk of batchSize of them, on up to concurrency workers:
+//   - Gets detailed transaction information, in batches when the source supports it
+//   - Sends the transaction data to the configured server endpoint, in a single
+//     compressed request when batchSize is greater than 1. When the server
+//     rejects a batch, its transactions are sent one at a time
+//
+// Results are printed and counted in ascending order of transaction ID, so
+// the output does not depend on the concurrency.
+//
+// A transaction that cannot be read, or that the server rejects, is recorded
+// as a failure and does not stop the others. Once the server becomes
+// unavailable, the transactions not sent yet are stored in the spool instead,
+// to be replayed by the next build, and the error that made the server
+// unavailable is returned; without a spool, the build stops there.
 //
 // Parameters:
+//   - source: history source the transactions are read from
+//   - sp: spool for the transactions that cannot be sent, or nil if disabled
 //   - machineId: string identifier for the machine
 //   - hostname: system hostname
-//   - savedTransactions: slice of previously processed transaction IDs to avoid duplication
+//   - savedSet: set of previously processed transaction IDs to avoid duplication
+//   - concurrency: number of chunks of transactions read and sent at the same time
+//   - batchSize: number of transactions sent per request, see uploadBatchSize
+//   - maxBodySize: size above which a transaction is sent in chunks, see uploadMaxBodySize
 //
 // Returns:
-//   - int: total number of entries processed
+//   - int: total number of entries processed, including the failed ones
 //   - int: number of new entries sent to server
-//   - error: any error encountered during execution
-func saveUnsentTransactions(machineId, hostname string, savedTransactions []int) (int, int, error) {
-	out, err := exec.Command(util.PackageBinary(), "history", "--reverse", "list").Output()
+//   - []transactionFailure: transactions that could not be read or sent, in ascending order
+//   - error: the error that stopped the build, if any; the counts are accurate even then
+func saveUnsentTransactions(source HistorySource, sp *spool.Spool, machineId, hostname string, savedSet map[string]struct{}, concurrency, batchSize, maxBodySize int) (int, int, []transactionFailure, error) {
+	entries, err := source.Entries()
 	if err != nil {
-		return 0, 0, err
-	}
-
-	output := string(out)
-	lines := strings.Split(output, "\n")
-	if len(lines) < 3 {
-		return 0, 0, nil
+		return 0, 0, nil, err
 	}
-	lines = lines[2:]
 
-	entriesProcessed := 0
 	entriesSent := 0
 
-	// Build a set for O(1) lookup of saved transaction IDs
-	savedSet := make(map[string]struct{}, len(savedTransactions))
-	for _, t := range savedTransactions {
-		savedSet[fmt.Sprintf("%d", t)] = struct{}{}
+	// Transactions not saved yet are read in batches, as reading them one at
+	// a time takes minutes on hosts with a long history
+	unsent, unsentIDs := unsentTransactions(entries, savedSet)
+	entriesProcessed := len(entries) - len(unsent)
+
+	client := util.NewServerClient()
+	reader := newTransactionReader(source, unsentIDs)
+
+	// offline is set by the first worker finding the server unavailable, so
+	// that the others spool their transactions without trying to send them
+	var offline atomic.Pointer[ServerUnavailableError]
+
+	// unbatched is set once the server is found not to accept batches, so
+	// that the transactions are sent one at a time from then on
+	var unbatched atomic.Bool
+
+	// skip marks the transactions of results not sent yet as unavailable,
+	// once the server is offline
+	skip := func(results []transactionUpload, unavailable *ServerUnavailableError) {
+		for i := range results {
+			if results[i].err == nil && !results[i].sent {
+				results[i].unavailable = unavailable
+			}
+		}
 	}
 
-	client := resty.New()
-	client.SetTimeout(30 * time.Second)
+	// send posts a transaction, in chunks if needed, and records the outcome in result
+	send := func(result *transactionUpload) {
+		err := result.encoded.send(client, resty.MethodPost)
+		var unavailable *ServerUnavailableError
+		switch {
+		case err == nil:
+			result.sent = true
+		case errors.As(err, &unavailable):
+			offline.CompareAndSwap(nil, unavailable)
+			result.unavailable = err
+		default:
+			result.err = err
+		}
+	}
 
-	for _, line := range lines {
-		matches := reHistoryLine.FindStringSubmatch(line)
-		if matches != nil {
-			transactionID := strings.TrimSpace(matches[1])
+	// upload reads and sends the c-th chunk of batchSize transactions
+	batchSize = max(batchSize, 1)
+	upload := func(c int) []transactionUpload {
+		start := c * batchSize
+		results := make([]transactionUpload, min(batchSize, len(unsent)-start))
+
+		// Transactions too large for a single request are left out of the
+		// batch, to be sent in chunks
+		var batch []int
+		for i := range results {
+			details, err := reader.read(start + i)
+			if err != nil {
+				results[i].err = err
+				continue
+			}
+			results[i].body = transactionPayload(unsent[start+i], details, machineId, hostname)
+			if results[i].encoded, err = encodeTransaction(results[i].body, maxBodySize); err != nil {
+				results[i].err = err
+				continue
+			}
+			if batchSize > 1 && results[i].encoded.fits() {
+				batch = append(batch, i)
+			}
+		}
 
-			if _, exists := savedSet[transactionID]; !exists {
-				details, err := getTransactionItems(transactionID)
-				if err != nil {
-					return 0, 0, err
-				}
+		if unavailable := offline.Load(); unavailable != nil {
+			skip(results, unavailable)
+			return results
+		}
 
-				request := client.R().
-					SetHeader("Content-Type", "application/json").
-					SetBody(map[string]interface{}{
-						"transaction_id":   transactionID,
-						"machine_id":       machineId,
-						"hostname":         hostname,
-						"begin_time":       details.BeginTime,
-						"end_time":         details.EndTime,
-						"actions":          strings.TrimSpace(matches[4]),
-						"altered":          strings.TrimSpace(matches[5]),
-						"user":             details.User,
-						"return_code":      details.ReturnCode,
-						"release_version":  details.Releasever,
-						"command_line":     details.CommandLine,
-						"comment":          details.Comment,
-						"scriptlet_output": strings.Join(details.ScriptletOutput, "\n"),
-						"items":            details.PackagesAltered,
-					})
-
-				util.SetAuthentication(request)
-
-				response, err := request.Post(viper.GetString("server.url") + "/v1/transactions")
+		if len(batch) > 0 && !unbatched.Load() {
+			transactions := make([]*encodedTransaction, len(batch))
+			for j, i := range batch {
+				transactions[j] = results[i].encoded
+			}
 
-				if err != nil {
-					return 0, 0, err
+			err := postTransactionBatch(client, transactions, maxBodySize)
+			var unavailable *ServerUnavailableError
+			switch {
+			case err == nil:
+				for _, i := range batch {
+					results[i].sent = true
 				}
+			case errors.As(err, &unavailable):
+				offline.CompareAndSwap(nil, unavailable)
+				skip(results, unavailable)
+				return results
+			case errors.Is(err, errBatchUnsupported):
+				unbatched.Store(true)
+			}
+			// Otherwise the server rejected the batch: the transactions are
+			// sent one at a time, so that only the faulty one fails
+		}
 
-				if response.StatusCode() != 200 {
-					return 0, 0, fmt.Errorf("server returned status code %d", response.StatusCode())
-				}
+		for i := range results {
+			if results[i].err != nil || results[i].sent {
+				continue
+			}
+			// Another worker may have found the server unavailable meanwhile
+			if unavailable := offline.Load(); unavailable != nil {
+				skip(results[i:], unavailable)
+				break
+			}
+			send(&results[i])
+		}
+		return results
+	}
 
+	var unavailableErr error
+	var failures []transactionFailure
+	spooled := 0
+	chunks := (len(unsent) + batchSize - 1) / batchSize
+	err = runOrdered(concurrency, chunks, upload, func(c int, results []transactionUpload) error {
+		for i, result := range results {
+			transactionID := unsent[c*batchSize+i].TransactionID
+			if result.err != nil {
+				failures = append(failures, transactionFailure{TransactionID: transactionID, Err: result.err})
+				entriesProcessed++
+				color.Red("   ✗ Transaction #%s failed: %v", transactionID, result.err)
+				continue
+			}
+
+			if result.sent {
 				entriesSent++
+				entriesProcessed++
 				color.Green("   ✓ Transaction #%s sent successfully", transactionID)
+				continue
+			}
+
+			if result.unavailable != nil && unavailableErr == nil {
+				unavailableErr = result.unavailable
+				color.Red("   ✗ Server unavailable: %v", unavailableErr)
+			}
+			if sp == nil {
+				// Without a spool, an unavailable server stops the build
+				return unavailableErr
 			}
+
+			if err := spoolPayload(sp, spool.KindTransaction, transactionID, result.body); err != nil {
+				return fmt.Errorf("failed to spool transaction #%s: %w", transactionID, err)
+			}
+			spooled++
 			entriesProcessed++
+			color.Yellow("   ⏸ Transaction #%s spooled", transactionID)
 		}
+		return nil
+	})
+	if err != nil {
+		return entriesProcessed, entriesSent, failures, err
 	}
 
-	return entriesProcessed, entriesSent, nil
+	if unavailableErr != nil {
+		return entriesProcessed, entriesSent, failures, fmt.Errorf("%w (%d transactions spooled in %s)", unavailableErr, spooled, sp.Dir())
+	}
+
+	return entriesProcessed, entriesSent, failures, nil
+}
+
+// unsentTransactions returns the entries not in savedSet, and their IDs.
+func unsentTransactions(entries []HistoryEntry, savedSet map[string]struct{}) ([]HistoryEntry, []string) {
+	unsent := make([]HistoryEntry, 0, len(entries))
+	unsentIDs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if _, exists := savedSet[entry.TransactionID]; !exists {
+			unsent = append(unsent, entry)
+			unsentIDs = append(unsentIDs, entry.TransactionID)
+		}
+	}
+	return unsent, unsentIDs
+}
+
+// transactionUpload is the outcome of reading and sending a transaction.
+type transactionUpload struct {
+	body    map[string]interface{}
+	encoded *encodedTransaction
+	sent    bool
+	// unavailable is set when the transaction could not be sent because the
+	// server was unavailable, and has to be spooled
+	unavailable error
+	err         error
 }
 
-func getTransactionItems(transactionID string) (TransactionDetail, error) {
-	if !reValidInput.MatchString(transactionID) {
-		return TransactionDetail{}, fmt.Errorf("invalid input")
+// transactionPayload builds the body sent to the server for a transaction.
+func transactionPayload(entry HistoryEntry, details TransactionDetail, machineId, hostname string) map[string]interface{} {
+	// Some sources, like dnf5, do not list the actions of each transaction
+	if entry.Actions == "" {
+		entry.Actions, entry.Altered = summarizePackageActions(details.PackagesAltered)
 	}
-	out, err := exec.Command(util.PackageBinary(), "history", "info", transactionID).Output()
+
+	return map[string]interface{}{
+		"transaction_id":   details.TransactionID,
+		"machine_id":       machineId,
+		"hostname":         hostname,
+		"begin_time":       details.BeginTime,
+		"end_time":         details.EndTime,
+		"actions":          entry.Actions,
+		"altered":          entry.Altered,
+		"user":             details.User,
+		"return_code":      details.ReturnCode,
+		"status":           details.Status,
+		"exit_code":        details.ExitCode,
+		"error_message":    details.ErrorMessage,
+		"release_version":  details.Releasever,
+		"command_line":     details.CommandLine,
+		"comment":          details.Comment,
+		"scriptlet_output": outputLines(details.ScriptletOutput),
+		"scriptlets":       details.Scriptlets,
+		"items":            details.PackagesAltered,
+		"changes":          details.PackageChanges,
+		"idempotency_key":  transactionIdempotencyKey(machineId, details),
+	}
+}
+
+// uploadTransaction sends the encoded transaction data to the server with
+// method, POST to save it or PUT to replace the server's copy, under the
+// idempotency key of the transaction. It returns a ServerUnavailableError
+// when the server could not be reached.
+func uploadTransaction(client *resty.Client, method, key string, data []byte) error {
+	request := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(data)
+
+	util.SetAuthentication(request)
+	if key != "" {
+		request.SetHeader(idempotencyKeyHeader, key)
+	}
+
+	response, err := request.Execute(method, viper.GetString("server.url")+"/v1/transactions")
+
 	if err != nil {
-		return TransactionDetail{}, err
+		return &ServerUnavailableError{Err: err}
 	}
 
-	output := string(out)
-	lines := strings.Split(output, "\n")
-
-	var transaction TransactionDetail
-	var packages []Package
-	var scriptletOutput []string
-	for _, line := range lines {
-		if matches := rePackageInstall.FindStringSubmatch(line); matches != nil {
-			name, version, release, epoch, arch := util.SplitPackageName(strings.TrimSpace(matches[2]))
-			pkg := Package{
-				Action:  strings.TrimSpace(matches[1]),
-				Name:    name,
-				Version: version,
-				Release: release,
-				Epoch:   epoch,
-				Arch:    arch,
-				Repo:    strings.TrimSpace(matches[3]),
-			}
-			packages = append(packages, pkg)
-		} else if matches := rePackageUpgraded.FindStringSubmatch(line); matches != nil {
-			name, version, release, epoch, arch := util.SplitPackageName(strings.TrimSpace(matches[2]))
-			pkg := Package{
-				Action:   strings.TrimSpace(matches[1]),
-				Name:     name,
-				Version:  version,
-				Release:  release,
-				Epoch:    epoch,
-				Arch:     arch,
-				FromRepo: strings.TrimSpace(matches[3]),
-			}
-			packages = append(packages, pkg)
-		} else if strings.HasPrefix(line, "  ") {
-			scriptletOutput = append(scriptletOutput, strings.TrimSpace(line))
-		} else if matches := reTransactionField.FindStringSubmatch(line); matches != nil {
-			key := strings.TrimSpace(matches[1])
-			value := strings.TrimSpace(matches[2])
-			switch key {
-			case "Transaction ID":
-				transaction.TransactionID = value
-			case "Begin time":
-				beginTime, err := util.DateConversion(value)
-				if err != nil {
-					return TransactionDetail{}, err
-				}
-				transaction.BeginTime = beginTime
-			case "Begin rpmdb":
-				transaction.BeginRPMDB = value
-			case "End time":
-				endTime, err := util.DateConversion(strings.Split(value, " (")[0])
-				if err != nil {
-					return TransactionDetail{}, err
-				}
-				transaction.EndTime = endTime
-			case "End rpmdb":
-				transaction.EndRPMDB = value
-			case "User":
-				transaction.User = value
-			case "Return-Code":
-				transaction.ReturnCode = value
-			case "Releasever":
-				transaction.Releasever = value
-			case "Command Line":
-				transaction.CommandLine = value
-			case "Comment":
-				transaction.Comment = value
+	if response.StatusCode() != 200 {
+		err := &ServerStatusError{StatusCode: response.StatusCode()}
+		if serverUnavailable(response, nil) {
+			return &ServerUnavailableError{Err: err}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// saveExecution sends the execution details to the server.
+func saveExecution(success bool, machineId, hostname, details string, processed, sent, failed int) error {
+	body, err := executionPayload(success, machineId, hostname, details, processed, sent, failed)
+	if err != nil {
+		return err
+	}
+
+	client := util.NewServerClient()
+	request := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(body)
+
+	util.SetAuthentication(request)
+	setIdempotencyKey(request, body)
+
+	response, err := request.Post(viper.GetString("server.url") + "/v1/executions")
+
+	if serverUnavailable(response, err) {
+		// The execution report is spooled like the transactions, so that the
+		// server eventually learns about the builds it missed
+		if sp := openSpool(); sp != nil {
+			if spoolErr := spoolPayload(sp, spool.KindExecution, fmt.Sprintf("%d", time.Now().UnixNano()), body); spoolErr == nil {
+				color.Yellow("⏸ Execution report spooled in %s", sp.Dir())
+				return nil
 			}
 		}
 	}
 
-	transaction.PackagesAltered = packages
-	transaction.ScriptletOutput = scriptletOutput
+	if err != nil {
+		return err
+	}
 
-	return transaction, nil
+	if response.StatusCode() != 200 {
+		return fmt.Errorf("server returned status code %d: %s", response.StatusCode(), response.String())
+	}
+
+	return nil
 }
 
-// saveExecution sends the execution details to the server.
-func saveExecution(success bool, machineId, hostname, details string, processed, sent int) error {
+// executionPayload builds the body of the execution report sent to the server.
+func executionPayload(success bool, machineId, hostname, details string, processed, sent, failed int) (map[string]interface{}, error) {
 	err := util.ParseOSRelease()
 	if err != nil {
-		return fmt.Errorf("error while reading /etc/os-release file: %w", err)
+		return nil, fmt.Errorf("error while reading /etc/os-release file: %w", err)
 	}
 
 	// * retrieves the server version
@@ -360,39 +712,19 @@ func saveExecution(success bool, machineId, hostname, details string, processed,
 		"success":                success,
 		"transactions_processed": processed,
 		"transactions_sent":      sent,
+		"transactions_failed":    failed,
 		"agent_version":          agentVersion,
 		"os":                     util.Release.PrettyName,
 	}
 
 	// Check if server supports needs_restarting feature (requires version >= 1.8.0)
-	if serverVersion != "unknown" {
-		sv, err := semver.NewVersion(serverVersion)
-		minVersion := semver.MustParse("1.8.0")
-
-		if err == nil && !sv.LessThan(minVersion) {
-			needsRestarting, reason := util.NeedsRestarting()
-			body["needs_restarting"] = needsRestarting
-			body["restarting_reason"] = reason
-		}
+	if serverVersionAtLeast(serverVersion, "1.8.0") {
+		needsRestarting, reason := util.NeedsRestarting()
+		body["needs_restarting"] = needsRestarting
+		body["restarting_reason"] = reason
 	}
 
-	client := resty.New()
-	client.SetTimeout(30 * time.Second)
-	request := client.R().
-		SetHeader("Content-Type", "application/json").
-		SetBody(body)
+	body["idempotency_key"] = executionIdempotencyKey(body)
 
-	util.SetAuthentication(request)
-
-	response, err := request.Post(viper.GetString("server.url") + "/v1/executions")
-
-	if err != nil {
-		return err
-	}
-
-	if response.StatusCode() != 200 {
-		return fmt.Errorf("server returned status code %d: %s", response.StatusCode(), response.String())
-	}
-
-	return nil
+	return body, nil
 }
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/txlog/agent/internal/spool"
+)
+
+// failingHistorySource fails to read the transactions in failing.
+type failingHistorySource struct {
+	stubHistorySource
+	failing map[string]bool
+}
+
+func (s *failingHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	if s.failing[transactionID] {
+		return TransactionDetail{}, errTest
+	}
+	return s.stubHistorySource.Transaction(transactionID)
+}
+
+func TestSaveUnsentTransactions_Failures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["transaction_id"] == "4" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	source := &failingHistorySource{failing: map[string]bool{"2": true}}
+	for i := 1; i <= 5; i++ {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: strconv.Itoa(i)})
+	}
+
+	processed, sent, failures, err := saveUnsentTransactions(source, nil, "machine", "host", map[string]struct{}{}, 2, 1, 0)
+	if err != nil {
+		t.Fatalf("saveUnsentTransactions() error = %v", err)
+	}
+	if processed != 5 || sent != 3 {
+		t.Errorf("saveUnsentTransactions() = %d processed, %d sent; want 5 and 3", processed, sent)
+	}
+	if len(failures) != 2 || failures[0].TransactionID != "2" || failures[1].TransactionID != "4" {
+		t.Fatalf("failures = %v, want transactions 2 and 4", failures)
+	}
+	if !errors.Is(failures[0].Err, errTest) {
+		t.Errorf("failure of transaction 2 = %v, want the read error", failures[0].Err)
+	}
+}
+
+func TestSaveUnsentTransactions_ServerUnavailable(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	viper.Set("server.retry.max_attempts", 1)
+	defer viper.Reset()
+
+	source := &stubHistorySource{}
+	for i := 1; i <= 5; i++ {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: strconv.Itoa(i)})
+	}
+
+	// Without a spool, an unavailable server stops the build, but the counts
+	// of what was already sent are kept
+	processed, sent, failures, err := saveUnsentTransactions(source, nil, "machine", "host", map[string]struct{}{}, 1, 1, 0)
+	var unavailable *ServerUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("saveUnsentTransactions() error = %v, want a ServerUnavailableError", err)
+	}
+	if processed != 1 || sent != 1 || len(failures) != 0 {
+		t.Errorf("saveUnsentTransactions() = %d processed, %d sent, %v failed; want 1, 1 and none", processed, sent, failures)
+	}
+}
+
+// outageHistorySource fails to read the transactions in failing, and only
+// returns transaction 1 once the server went down.
+type outageHistorySource struct {
+	failingHistorySource
+	outage chan struct{}
+}
+
+func (s *outageHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	if transactionID == "1" {
+		<-s.outage
+		// Leave the worker sending transaction 3 the time to find the
+		// server unavailable
+		time.Sleep(50 * time.Millisecond)
+	}
+	return s.failingHistorySource.Transaction(transactionID)
+}
+
+func TestSaveUnsentTransactions_OutageInFlight(t *testing.T) {
+	var once sync.Once
+	outage := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(outage) })
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	viper.Set("server.retry.max_attempts", 1)
+	defer viper.Reset()
+
+	newSource := func() *outageHistorySource {
+		source := &outageHistorySource{
+			failingHistorySource: failingHistorySource{failing: map[string]bool{"2": true}},
+			outage:               outage,
+		}
+		for i := 1; i <= 3; i++ {
+			source.entries = append(source.entries, HistoryEntry{TransactionID: strconv.Itoa(i)})
+		}
+		return source
+	}
+
+	// Transaction 1 is still being read by a worker when the one sending
+	// transaction 3 finds the server down. Without a spool, the build stops
+	// at transaction 1, the first one not sent
+	processed, sent, failures, err := saveUnsentTransactions(newSource(), nil, "machine", "host", map[string]struct{}{}, 3, 1, 0)
+	var unavailable *ServerUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("saveUnsentTransactions() error = %v, want a ServerUnavailableError", err)
+	}
+	if processed != 0 || sent != 0 || len(failures) != 0 {
+		t.Errorf("saveUnsentTransactions() = %d processed, %d sent, %v failed; want none", processed, sent, failures)
+	}
+
+	// With a spool, every transaction is accounted for
+	sp, err := spool.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	processed, sent, failures, err = saveUnsentTransactions(newSource(), sp, "machine", "host", map[string]struct{}{}, 3, 1, 0)
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("saveUnsentTransactions() error = %v, want a ServerUnavailableError", err)
+	}
+	if processed != 3 || sent != 0 || len(failures) != 1 || failures[0].TransactionID != "2" {
+		t.Errorf("saveUnsentTransactions() = %d processed, %d sent, %v failed; want 3, 0 and transaction 2", processed, sent, failures)
+	}
+	if entries, _ := sp.Entries(); len(entries) != 2 || entries[0].Key != "1" || entries[1].Key != "3" {
+		t.Errorf("spool = %+v, want transactions 1 and 3", entries)
+	}
+}
+
+func TestFailuresSummary(t *testing.T) {
+	summary := failuresSummary([]transactionFailure{
+		{TransactionID: "2", Err: errTest},
+		{TransactionID: "4", Err: errors.New("server returned status code 400")},
+	})
+
+	expected := "2 transactions failed\n#2: test error\n#4: server returned status code 400"
+	if summary != expected {
+		t.Errorf("failuresSummary() = %q, want %q", summary, expected)
+	}
+}
@@ -0,0 +1,479 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+	"github.com/txlog/agent/util"
+)
+
+// errChunksUnsupported is returned when the server has no endpoint for
+// transactions sent in chunks, and they must be sent in a single request.
+var errChunksUnsupported = errors.New("server does not support transactions sent in chunks")
+
+// chunkedUploadVersion is the version of the reassembly contract described on
+// transactionChunker. It is sent with every chunk, in the chunk_version field
+// and the Txlog-Chunk-Version header, and changes whenever the server would
+// need to reassemble the chunks differently.
+const chunkedUploadVersion = 1
+
+// chunkVersionHeader carries chunkedUploadVersion on every chunk request.
+const chunkVersionHeader = "Txlog-Chunk-Version"
+
+// defaultMaxBodySize is used when the server.max_body_size setting is not
+// set. It matches the default body size limit of common reverse proxies.
+const defaultMaxBodySize = 1 << 20
+
+// minMaxBodySize is the smallest body size accepted, so that a chunk always
+// has room for the transaction fields and a few items.
+const minMaxBodySize = 16 << 10
+
+// chunkedFields are the fields of a transaction payload split across chunks,
+// in the order they are sent.
+var chunkedFields = []string{"items", "changes", "scriptlets", "scriptlet_output"}
+
+// uploadMaxBodySize returns the size, in bytes, above which a transaction is
+// sent in chunks. It is 0, meaning every transaction is sent in a single
+// request, when server.max_body_size is set to 0. Servers without chunks are
+// detected on the first chunk sent, see errChunksUnsupported.
+func uploadMaxBodySize() int {
+	size := defaultMaxBodySize
+	if viper.IsSet("server.max_body_size") {
+		size = int(viper.GetSizeInBytes("server.max_body_size"))
+	}
+	if size <= 0 {
+		return 0
+	}
+
+	return max(size, minMaxBodySize)
+}
+
+// transactionChunk is a part of a transaction payload, as sent to the server.
+type transactionChunk struct {
+	index int
+	data  []byte
+	final bool
+}
+
+// transactionChunker splits a transaction payload into chunks whose JSON
+// encoding is at most maxSize bytes. The elements of the chunked fields are
+// encoded one at a time, as chunks are requested, so that the payload is
+// never encoded as a whole.
+//
+// Each chunk is a JSON object with, in version 1 of the reassembly contract:
+//   - chunk_version: 1
+//   - upload_id: the idempotency key of the transaction
+//   - machine_id and transaction_id
+//   - chunk_index: 0 for the first chunk, incremented by one for each next one
+//   - final: true on the last chunk only
+//   - transaction: on the first chunk only, every field of the payload but the chunked ones
+//   - items, changes and scriptlets: arrays, concatenated in chunk_index order
+//   - scriptlet_output: an array of strings, joined without separator in chunk_index order
+//
+// The server saves the transaction once it has the final chunk and every
+// chunk before it. A chunk received twice, which the Idempotency-Key header
+// of each chunk identifies, replaces the first one.
+type transactionChunker struct {
+	body    map[string]interface{}
+	maxSize int
+	lists   []reflect.Value
+
+	index   int
+	field   int    // field of the next element
+	element int    // index of the next element in its field
+	pending []byte // encoded element that did not fit in the last chunk
+}
+
+// newTransactionChunker returns a chunker for body, a transaction payload
+// as built by transactionPayload or decoded from the spool.
+func newTransactionChunker(body map[string]interface{}, maxSize int) *transactionChunker {
+	c := &transactionChunker{body: body, maxSize: maxSize}
+	for _, field := range chunkedFields {
+		value := body[field]
+
+		// Leaves room for the escaping of the output, up to 6 bytes per byte
+		switch output := value.(type) {
+		case string:
+			value = splitString(output, maxSize/16)
+		case outputLines:
+			value = output.pieces(maxSize / 16)
+		}
+
+		list := reflect.ValueOf(value)
+		if list.Kind() != reflect.Slice {
+			list = reflect.ValueOf([]interface{}{})
+		}
+		c.lists = append(c.lists, list)
+	}
+	return c
+}
+
+// next returns the next chunk of the payload. It must not be called after
+// the final chunk.
+func (c *transactionChunker) next() (transactionChunk, error) {
+	chunk := transactionChunk{index: c.index}
+	c.index++
+
+	var buf bytes.Buffer
+	header, err := c.header(chunk.index)
+	if err != nil {
+		return chunk, err
+	}
+	buf.Write(header)
+
+	const trailer = `],"final":false}`
+	if buf.Len()+len(trailer) > c.maxSize {
+		return chunk, fmt.Errorf("transaction #%v has fields larger than the maximum body size of %d bytes", c.body["transaction_id"], c.maxSize)
+	}
+	open := -1
+	elements := 0
+	for {
+		if c.pending == nil {
+			if c.pending, err = c.nextElement(); err != nil {
+				return chunk, err
+			}
+			if c.pending == nil {
+				break
+			}
+		}
+
+		var separator string
+		if open == c.field {
+			separator = ","
+		} else {
+			if open >= 0 {
+				separator = "]"
+			}
+			separator += `,"` + chunkedFields[c.field] + `":[`
+		}
+
+		if buf.Len()+len(separator)+len(c.pending)+len(trailer) > c.maxSize {
+			if elements == 0 {
+				return chunk, fmt.Errorf("transaction #%v has a %s element larger than the maximum body size of %d bytes", c.body["transaction_id"], chunkedFields[c.field], c.maxSize)
+			}
+			break
+		}
+
+		buf.WriteString(separator)
+		buf.Write(c.pending)
+		open = c.field
+		elements++
+		c.pending = nil
+		c.element++
+	}
+
+	if open >= 0 {
+		buf.WriteString("]")
+	}
+	chunk.final = c.pending == nil
+	fmt.Fprintf(&buf, `,"final":%t}`, chunk.final)
+
+	chunk.data = buf.Bytes()
+	return chunk, nil
+}
+
+// header returns the JSON encoding of the fields of the chunk at index other
+// than the chunked ones, without the closing brace.
+func (c *transactionChunker) header(index int) ([]byte, error) {
+	fields := map[string]interface{}{
+		"chunk_version":  chunkedUploadVersion,
+		"upload_id":      idempotencyKey(c.body),
+		"machine_id":     c.body["machine_id"],
+		"transaction_id": c.body["transaction_id"],
+		"chunk_index":    index,
+	}
+
+	if index == 0 {
+		fields["transaction"] = unchunkedFields(c.body)
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return data[:len(data)-1], nil
+}
+
+// unchunkedFields returns the fields of body other than the chunked ones.
+func unchunkedFields(body map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(body))
+	for key, value := range body {
+		fields[key] = value
+	}
+	for _, field := range chunkedFields {
+		delete(fields, field)
+	}
+	return fields
+}
+
+// nextElement returns the JSON encoding of the next element of the chunked
+// fields, moving to the next field when needed, or nil when none is left.
+func (c *transactionChunker) nextElement() ([]byte, error) {
+	for c.field < len(c.lists) && c.element >= c.lists[c.field].Len() {
+		c.field++
+		c.element = 0
+	}
+	if c.field == len(c.lists) {
+		return nil, nil
+	}
+
+	return json.Marshal(c.lists[c.field].Index(c.element).Interface())
+}
+
+// splitString splits s into pieces of at most size bytes, without splitting
+// multi-byte characters.
+func splitString(s string, size int) []string {
+	size = max(size, utf8.UTFMax)
+
+	var pieces []string
+	for len(s) > size {
+		end := size
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		pieces = append(pieces, s[:end])
+		s = s[end:]
+	}
+	if s != "" {
+		pieces = append(pieces, s)
+	}
+	return pieces
+}
+
+// outputLines is the scriptlet output of a transaction payload. It is encoded
+// as the lines joined by newlines, but kept as lines so that the output of a
+// large transaction is not copied into a single string to be sent in chunks.
+type outputLines []string
+
+// MarshalJSON encodes the output as a single string.
+func (o outputLines) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.Join(o, "\n"))
+}
+
+// size returns the length of the output, in bytes, before encoding.
+func (o outputLines) size() int {
+	size := max(len(o)-1, 0)
+	for _, line := range o {
+		size += len(line)
+	}
+	return size
+}
+
+// pieces splits the output into pieces of at most size bytes, each line
+// starting a new piece.
+func (o outputLines) pieces(size int) []outputPiece {
+	var pieces []outputPiece
+	for i, line := range o {
+		split := splitString(line, size)
+		if len(split) == 0 {
+			split = []string{""}
+		}
+		for j, text := range split {
+			pieces = append(pieces, outputPiece{text: text, newline: j == len(split)-1 && i < len(o)-1})
+		}
+	}
+	return pieces
+}
+
+// outputPiece is a piece of a line of outputLines, followed by a newline
+// when it ends a line other than the last.
+type outputPiece struct {
+	text    string
+	newline bool
+}
+
+// MarshalJSON encodes the piece as a string.
+func (p outputPiece) MarshalJSON() ([]byte, error) {
+	if p.newline {
+		return json.Marshal(p.text + "\n")
+	}
+	return json.Marshal(p.text)
+}
+
+// encodedTransaction is a transaction payload ready to be uploaded: key is
+// its idempotency key, data holds its JSON encoding when it fits in a single
+// request, and chunker its chunks otherwise.
+type encodedTransaction struct {
+	body    map[string]interface{}
+	key     string
+	data    []byte
+	chunker *transactionChunker
+}
+
+// encodeTransaction encodes body for requests of at most maxSize bytes, or
+// of any size when maxSize is 0.
+func encodeTransaction(body map[string]interface{}, maxSize int) (*encodedTransaction, error) {
+	data, err := encodeBody(body, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	transaction := &encodedTransaction{body: body, key: idempotencyKey(body), data: data}
+	if data == nil {
+		transaction.chunker = newTransactionChunker(body, maxSize)
+	}
+	return transaction, nil
+}
+
+// encodeBody returns the JSON encoding of body, or nil when it is larger
+// than maxSize bytes. The chunked fields are encoded one element at a time,
+// and the encoding stops as soon as it is too large, so that a giant
+// transaction is never encoded as a whole. Any body fits when maxSize is 0.
+func encodeBody(body map[string]interface{}, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		return json.Marshal(body)
+	}
+
+	data, err := json.Marshal(unchunkedFields(body))
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(data[:len(data)-1])
+
+	for _, field := range chunkedFields {
+		value, ok := body[field]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(`"` + field + `":`)
+
+		// The output is at least as large once encoded
+		switch output := value.(type) {
+		case string:
+			if len(output) > maxSize {
+				return nil, nil
+			}
+		case outputLines:
+			if output.size() > maxSize {
+				return nil, nil
+			}
+		}
+
+		list := reflect.ValueOf(value)
+		if _, ok := value.(json.Marshaler); ok || list.Kind() != reflect.Slice || list.IsNil() {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+		} else {
+			buf.WriteString("[")
+			for i := 0; i < list.Len() && buf.Len() <= maxSize; i++ {
+				if i > 0 {
+					buf.WriteString(",")
+				}
+				data, err := json.Marshal(list.Index(i).Interface())
+				if err != nil {
+					return nil, err
+				}
+				buf.Write(data)
+			}
+			buf.WriteString("]")
+		}
+
+		if buf.Len() > maxSize {
+			return nil, nil
+		}
+	}
+	buf.WriteString("}")
+
+	if buf.Len() > maxSize {
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// fits reports whether the transaction is sent in a single request.
+func (t *encodedTransaction) fits() bool {
+	return t.chunker == nil
+}
+
+// send uploads the transaction with method, POST to save it or PUT to
+// replace the server's copy, in a single request when it fits and in chunks
+// otherwise. A server that does not accept chunks gets the whole transaction
+// in a single request instead. The chunks of a transaction can only be sent
+// once. It returns a ServerUnavailableError when the server could not be
+// reached.
+func (t *encodedTransaction) send(client *resty.Client, method string) error {
+	if t.fits() {
+		return uploadTransaction(client, method, t.key, t.data)
+	}
+
+	for {
+		chunk, err := t.chunker.next()
+		if err != nil {
+			return err
+		}
+		err = uploadTransactionChunk(client, method, t.key, chunk)
+		if errors.Is(err, errChunksUnsupported) && chunk.index == 0 {
+			data, err := json.Marshal(t.body)
+			if err != nil {
+				return err
+			}
+			return uploadTransaction(client, method, t.key, data)
+		}
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", chunk.index, err)
+		}
+		if chunk.final {
+			return nil
+		}
+	}
+}
+
+// sendTransaction sends a transaction to the server in a single request
+// when it fits in maxSize bytes, and in chunks otherwise. A maxSize of 0
+// always sends a single request. It returns a ServerUnavailableError when the
+// server could not be reached.
+func sendTransaction(client *resty.Client, body map[string]interface{}, maxSize int) error {
+	transaction, err := encodeTransaction(body, maxSize)
+	if err != nil {
+		return err
+	}
+	return transaction.send(client, resty.MethodPost)
+}
+
+// uploadTransactionChunk sends a chunk of the transaction identified by
+// uploadID with method: the server saves the reassembled transaction for a
+// POST, and replaces its copy with it for a PUT. It returns a
+// ServerUnavailableError when the server could not be reached, and
+// errChunksUnsupported when it does not accept chunks.
+func uploadTransactionChunk(client *resty.Client, method, uploadID string, chunk transactionChunk) error {
+	request := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader(chunkVersionHeader, strconv.Itoa(chunkedUploadVersion)).
+		SetHeader(idempotencyKeyHeader, hashKey(uploadID, strconv.Itoa(chunk.index))).
+		SetBody(chunk.data)
+
+	util.SetAuthentication(request)
+
+	response, err := request.Execute(method, viper.GetString("server.url")+"/v1/transactions/chunks")
+
+	if err != nil {
+		return &ServerUnavailableError{Err: err}
+	}
+
+	if endpointUnsupported(response.StatusCode()) {
+		return errChunksUnsupported
+	}
+
+	if response.StatusCode() != 200 {
+		err := &ServerStatusError{StatusCode: response.StatusCode()}
+		if serverUnavailable(response, nil) {
+			return &ServerUnavailableError{Err: err}
+		}
+		return err
+	}
+
+	return nil
+}
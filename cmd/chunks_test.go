@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+	"github.com/txlog/agent/util"
+)
+
+// largeTransactionPayload returns the payload of a transaction with n items
+// and a scriptlet output of about n lines.
+func largeTransactionPayload(n int) map[string]interface{} {
+	details := TransactionDetail{TransactionID: "42", BeginRPMDB: "1:a", EndRPMDB: "2:b", CommandLine: "upgrade"}
+	for i := range n {
+		details.PackagesAltered = append(details.PackagesAltered, Package{
+			Action: "Upgrade", Name: fmt.Sprintf("package-%d", i), Version: "1.0", Release: "1.el9", Arch: "x86_64", Repo: "baseos",
+		})
+		details.ScriptletOutput = append(details.ScriptletOutput, fmt.Sprintf("ação %d: \"done\"", i))
+	}
+	return transactionPayload(HistoryEntry{TransactionID: "42"}, details, "machine", "host")
+}
+
+func TestUploadMaxBodySize(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	if size := uploadMaxBodySize(); size != defaultMaxBodySize {
+		t.Errorf("uploadMaxBodySize() = %d, want the default %d", size, defaultMaxBodySize)
+	}
+
+	viper.Set("server.max_body_size", "64kb")
+	if size := uploadMaxBodySize(); size != 64<<10 {
+		t.Errorf("uploadMaxBodySize() = %d, want 64 KiB from the configuration", size)
+	}
+
+	viper.Set("server.max_body_size", 100)
+	if size := uploadMaxBodySize(); size != minMaxBodySize {
+		t.Errorf("uploadMaxBodySize() = %d, want at least %d", size, minMaxBodySize)
+	}
+
+	viper.Set("server.max_body_size", 0)
+	if size := uploadMaxBodySize(); size != 0 {
+		t.Errorf("uploadMaxBodySize() = %d, want 0 when chunks are disabled", size)
+	}
+}
+
+func TestTransactionChunker(t *testing.T) {
+	body := largeTransactionPayload(2000)
+	maxSize := minMaxBodySize
+
+	var items []Package
+	var output strings.Builder
+	chunker := newTransactionChunker(body, maxSize)
+	for i := 0; ; i++ {
+		chunk, err := chunker.next()
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		if len(chunk.data) > maxSize {
+			t.Errorf("chunk %d is %d bytes, want at most %d", i, len(chunk.data), maxSize)
+		}
+
+		var decoded struct {
+			ChunkVersion    int                    `json:"chunk_version"`
+			UploadID        string                 `json:"upload_id"`
+			TransactionID   string                 `json:"transaction_id"`
+			ChunkIndex      int                    `json:"chunk_index"`
+			Final           bool                   `json:"final"`
+			Transaction     map[string]interface{} `json:"transaction"`
+			Items           []Package              `json:"items"`
+			ScriptletOutput []string               `json:"scriptlet_output"`
+		}
+		if err := json.Unmarshal(chunk.data, &decoded); err != nil {
+			t.Fatalf("chunk %d is not valid JSON: %v", i, err)
+		}
+		if decoded.ChunkVersion != chunkedUploadVersion || decoded.ChunkIndex != i || decoded.TransactionID != "42" || decoded.UploadID != body["idempotency_key"] {
+			t.Errorf("chunk %d has version %d, index %d, transaction %q and upload %q", i, decoded.ChunkVersion, decoded.ChunkIndex, decoded.TransactionID, decoded.UploadID)
+		}
+		if (i == 0) != (decoded.Transaction != nil) {
+			t.Errorf("chunk %d: transaction fields should be on the first chunk only", i)
+		}
+		if decoded.Transaction != nil && (decoded.Transaction["command_line"] != "upgrade" || decoded.Transaction["items"] != nil) {
+			t.Errorf("chunk %d: transaction = %v, want the fields that are not chunked", i, decoded.Transaction)
+		}
+
+		items = append(items, decoded.Items...)
+		for _, piece := range decoded.ScriptletOutput {
+			output.WriteString(piece)
+		}
+
+		if chunk.final {
+			if i < 2 {
+				t.Errorf("transaction sent in %d chunks, want several", i+1)
+			}
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(items, body["items"]) {
+		t.Errorf("reassembled %d items, want %d", len(items), len(body["items"].([]Package)))
+	}
+	if output.String() != strings.Join(body["scriptlet_output"].(outputLines), "\n") {
+		t.Error("reassembled scriptlet output differs from the original")
+	}
+}
+
+func TestEncodeTransaction(t *testing.T) {
+	body := largeTransactionPayload(10)
+
+	for _, maxSize := range []int{0, minMaxBodySize} {
+		transaction, err := encodeTransaction(body, maxSize)
+		if err != nil {
+			t.Fatalf("encodeTransaction() error = %v", err)
+		}
+		if !transaction.fits() {
+			t.Fatalf("encodeTransaction() should send a small transaction in a single request with a maximum of %d bytes", maxSize)
+		}
+
+		// The encoding is the one of the payload, whatever the order of its fields
+		var got, want map[string]interface{}
+		if err := json.Unmarshal(transaction.data, &got); err != nil {
+			t.Fatalf("encoding is not valid JSON: %v", err)
+		}
+		data, _ := json.Marshal(body)
+		json.Unmarshal(data, &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("encodeTransaction() = %s, want %s", transaction.data, data)
+		}
+	}
+
+	transaction, err := encodeTransaction(largeTransactionPayload(2000), minMaxBodySize)
+	if err != nil {
+		t.Fatalf("encodeTransaction() error = %v", err)
+	}
+	if transaction.fits() || transaction.data != nil {
+		t.Error("encodeTransaction() should send a large transaction in chunks")
+	}
+}
+
+func TestOutputLines(t *testing.T) {
+	output := outputLines{"first", "", strings.Repeat("é", 10), "last"}
+	joined := strings.Join(output, "\n")
+
+	if output.size() != len(joined) {
+		t.Errorf("size() = %d, want %d", output.size(), len(joined))
+	}
+	if data, _ := json.Marshal(output); string(data) != `"first\n\n`+strings.Repeat("é", 10)+`\nlast"` {
+		t.Errorf("output encoded as %s, want a single string", data)
+	}
+
+	var reassembled strings.Builder
+	for _, piece := range output.pieces(7) {
+		var text string
+		data, _ := json.Marshal(piece)
+		json.Unmarshal(data, &text)
+		if len(text) > 8 {
+			t.Errorf("piece %q is longer than 7 bytes and a newline", text)
+		}
+		reassembled.WriteString(text)
+	}
+	if reassembled.String() != joined {
+		t.Errorf("pieces add up to %q, want %q", reassembled.String(), joined)
+	}
+}
+
+func TestSplitString(t *testing.T) {
+	s := strings.Repeat("aé€😀", 100)
+	pieces := splitString(s, 7)
+
+	if strings.Join(pieces, "") != s {
+		t.Fatal("splitString() pieces do not add up to the original string")
+	}
+	for _, piece := range pieces {
+		if len(piece) > 7 || !utf8.ValidString(piece) {
+			t.Errorf("splitString() returned piece %q, want at most 7 bytes of whole characters", piece)
+		}
+	}
+
+	if pieces := splitString("", 7); len(pieces) != 0 {
+		t.Errorf("splitString() = %q for an empty string, want no piece", pieces)
+	}
+}
+
+func TestSendTransaction(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if len(data) > minMaxBodySize {
+			t.Errorf("request to %s is %d bytes, want at most %d", r.URL.Path, len(data), minMaxBodySize)
+		}
+		if r.Header.Get(idempotencyKeyHeader) == "" {
+			t.Errorf("request to %s sent without an idempotency key", r.URL.Path)
+		}
+		if r.URL.Path == "/v1/transactions/chunks" && r.Header.Get(chunkVersionHeader) != "1" {
+			t.Errorf("chunk sent with version %q, want 1", r.Header.Get(chunkVersionHeader))
+		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	client := util.NewServerClient()
+	if err := sendTransaction(client, largeTransactionPayload(10), minMaxBodySize); err != nil {
+		t.Fatalf("sendTransaction() error = %v", err)
+	}
+	if len(requests) != 1 || requests[0] != "POST /v1/transactions" {
+		t.Errorf("small transaction sent as %v, want a single request", requests)
+	}
+
+	requests = nil
+	if err := sendTransaction(client, largeTransactionPayload(2000), minMaxBodySize); err != nil {
+		t.Fatalf("sendTransaction() error = %v", err)
+	}
+	if len(requests) < 2 {
+		t.Errorf("large transaction sent as %v, want several chunks", requests)
+	}
+	for _, request := range requests {
+		if request != "POST /v1/transactions/chunks" {
+			t.Errorf("large transaction sent as %s, want POST /v1/transactions/chunks", request)
+		}
+	}
+
+	// A transaction replacing the server's copy is sent in chunks too
+	requests = nil
+	if err := putTransaction(client, largeTransactionPayload(2000), minMaxBodySize); err != nil {
+		t.Fatalf("putTransaction() error = %v", err)
+	}
+	if len(requests) < 2 {
+		t.Errorf("large transaction replaced with %v, want several chunks", requests)
+	}
+	for _, request := range requests {
+		if request != "PUT /v1/transactions/chunks" {
+			t.Errorf("large transaction replaced with %s, want PUT /v1/transactions/chunks", request)
+		}
+	}
+}
+
+func TestSendTransaction_ChunksUnsupported(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/v1/transactions/chunks" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body["items"].([]interface{})) != 2000 {
+			t.Errorf("transaction sent without its 2000 items (%v)", err)
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	// A server without chunks gets the whole transaction in a single request
+	if err := sendTransaction(util.NewServerClient(), largeTransactionPayload(2000), minMaxBodySize); err != nil {
+		t.Fatalf("sendTransaction() error = %v", err)
+	}
+	want := []string{"POST /v1/transactions/chunks", "POST /v1/transactions"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("large transaction sent as %v, want %v", requests, want)
+	}
+}
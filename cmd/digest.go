@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+	"github.com/txlog/agent/util"
+)
+
+// digestVersion is the version of the canonical form hashed by
+// transactionDigest and historyDigest. It is sent with every digest request,
+// and the server answers with the version it used; digests of another
+// version are not compared.
+const digestVersion = 1
+
+// errDigestUnsupported is returned when the server does not expose digests
+// of the current version, and the transactions must be compared in depth.
+var errDigestUnsupported = errors.New("server does not support transaction digests")
+
+// transactionDigest returns the hex-encoded SHA-256 of the canonical form of
+// a transaction, made of the fields and items verify compares. In version 1,
+// the canonical form is these lines, joined with "\n":
+//
+//	txlog-transaction-digest-v1
+//	transaction_id=<ID>
+//	begin_time=<begin time, in UTC, formatted as RFC 3339>
+//	end_time=<end time, in UTC, formatted as RFC 3339>
+//	user=<user>
+//	return_code=<return code>
+//	release_version=<release version>
+//	command_line=<command line>
+//	item=<action>|<name>|<epoch>|<version>|<release>|<arch>|<repo>
+//
+// with surrounding whitespace trimmed from every value, times that cannot be
+// parsed kept as text, and an item line per distinct item, sorted bytewise.
+// The repo of the replaced side of an upgrade or downgrade is "@" followed by
+// its from_repo, as compared by verify (see itemRepo).
+func transactionDigest(details TransactionDetail) string {
+	lines := []string{
+		"txlog-transaction-digest-v1",
+		"transaction_id=" + strings.TrimSpace(details.TransactionID),
+		"begin_time=" + normalizeTime(details.BeginTime),
+		"end_time=" + normalizeTime(details.EndTime),
+		"user=" + strings.TrimSpace(details.User),
+		"return_code=" + strings.TrimSpace(details.ReturnCode),
+		"release_version=" + strings.TrimSpace(details.Releasever),
+		"command_line=" + strings.TrimSpace(details.CommandLine),
+	}
+
+	items := make([]string, 0, len(details.PackagesAltered))
+	for _, pkg := range details.PackagesAltered {
+		items = append(items, fmt.Sprintf("item=%s|%s|%s|%s|%s|%s|%s", pkg.Action, pkg.Name, pkg.Epoch, pkg.Version, pkg.Release, pkg.Arch, itemRepo(pkg)))
+	}
+	slices.Sort(items)
+	lines = append(lines, slices.Compact(items)...)
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// historyDigest returns the rolling hash of transaction digests, given in
+// ascending order of transaction ID: starting from an empty string, each
+// digest is appended to the previous hash and hashed again, and the last hash
+// is returned, hex-encoded. It changes whenever any transaction changes, is
+// added or is removed.
+func historyDigest(digests []string) string {
+	root := ""
+	for _, digest := range digests {
+		sum := sha256.Sum256([]byte(root + digest))
+		root = hex.EncodeToString(sum[:])
+	}
+	return root
+}
+
+// serverHistoryDigest is the rolling hash of the transactions saved on the
+// server for a machine, as returned by /v1/transactions/digest.
+type serverHistoryDigest struct {
+	Version int    `json:"version"`
+	Count   int    `json:"count"`
+	Root    string `json:"root"`
+}
+
+// serverTransactionDigest is the digest of a transaction saved on the server,
+// as returned by /v1/transactions/digests.
+type serverTransactionDigest struct {
+	TransactionID string `json:"transaction_id"`
+	Digest        string `json:"digest"`
+}
+
+// getServerHistoryDigest retrieves the rolling hash of the transactions
+// saved on the server. It returns errDigestUnsupported when the server does
+// not expose digests of the current version.
+func getServerHistoryDigest(client *resty.Client, machineId, hostname string) (*serverHistoryDigest, error) {
+	var digest serverHistoryDigest
+	if err := getDigests(client, "/v1/transactions/digest", machineId, hostname, &digest); err != nil {
+		return nil, err
+	}
+	if digest.Version != digestVersion {
+		return nil, errDigestUnsupported
+	}
+	return &digest, nil
+}
+
+// getServerTransactionDigests retrieves the digest of each transaction saved
+// on the server, by transaction ID. It returns errDigestUnsupported when the
+// server does not expose digests of the current version.
+func getServerTransactionDigests(client *resty.Client, machineId, hostname string) (map[string]string, error) {
+	var response struct {
+		Version      int                       `json:"version"`
+		Transactions []serverTransactionDigest `json:"transactions"`
+	}
+	if err := getDigests(client, "/v1/transactions/digests", machineId, hostname, &response); err != nil {
+		return nil, err
+	}
+	if response.Version != digestVersion {
+		return nil, errDigestUnsupported
+	}
+
+	digests := make(map[string]string, len(response.Transactions))
+	for _, transaction := range response.Transactions {
+		digests[transaction.TransactionID] = transaction.Digest
+	}
+	return digests, nil
+}
+
+// getDigests requests one of the digest endpoints of the server and decodes
+// its response into result.
+func getDigests(client *resty.Client, endpoint, machineId, hostname string, result interface{}) error {
+	request := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetQueryParams(map[string]string{
+			"machine_id": machineId,
+			"hostname":   hostname,
+			"version":    strconv.Itoa(digestVersion),
+		}).
+		SetResult(result)
+
+	util.SetAuthentication(request)
+
+	response, err := request.Get(viper.GetString("server.url") + endpoint)
+
+	if err != nil {
+		return &ServerUnavailableError{Err: err}
+	}
+
+	switch response.StatusCode() {
+	case 200:
+		return nil
+	case 404:
+		return errDigestUnsupported
+	default:
+		return fmt.Errorf("server returned status code %d: %s", response.StatusCode(), response.String())
+	}
+}
+
+// compareDigests compares the digests of the transactions in transactionIDs,
+// in ascending order, with the ones saved on the server. Transactions whose
+// digest matches are recorded as verified in result. The others, and the ones
+// that could not be read, are returned, to be compared in depth, with the
+// details read of the ones that differ, so that they are not read again.
+//
+// When whole is true, transactionIDs is the whole history of both sides, and
+// the rolling hash of the history is compared first: when it matches, a
+// single request verifies every transaction. Until it is known to match, the
+// details of every transaction are kept, and they are pruned to the ones that
+// differ once the transaction digests of the server are fetched.
+func compareDigests(source HistorySource, client *resty.Client, machineId, hostname string, transactionIDs []string, whole bool, result *VerificationResult) ([]string, map[string]TransactionDetail, error) {
+	// The rolling hash is computed in ascending order of transaction ID
+	transactionIDs = slices.Clone(transactionIDs)
+	slices.SortFunc(transactionIDs, func(a, b string) int {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x - y
+	})
+
+	// Without a history digest to compare, the digests of the server are
+	// known before reading the local transactions
+	var root *serverHistoryDigest
+	var serverDigests map[string]string
+	var err error
+	if whole {
+		if root, err = getServerHistoryDigest(client, machineId, hostname); err != nil {
+			return nil, nil, err
+		}
+		whole = root.Count == len(transactionIDs)
+	}
+	if !whole {
+		if serverDigests, err = getServerTransactionDigests(client, machineId, hostname); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	fmt.Fprintf(color.Output, "Computing local transaction digests...\n")
+	localDigests := make(map[string]string, len(transactionIDs))
+	digests := make([]string, 0, len(transactionIDs))
+	readDetails := make(map[string]TransactionDetail)
+	err = eachTransaction(source, transactionIDs, func(transactionID string, details TransactionDetail, err error) error {
+		// Transactions that cannot be read are left to the in-depth comparison, which reports them
+		if err == nil {
+			localDigests[transactionID] = transactionDigest(details)
+			digests = append(digests, localDigests[transactionID])
+			if serverDigests == nil || localDigests[transactionID] != serverDigests[transactionID] {
+				readDetails[transactionID] = details
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if whole {
+		if len(digests) == len(transactionIDs) && root.Root == historyDigest(digests) {
+			color.Green("  ✓ History digest matches the server")
+			for _, transactionID := range transactionIDs {
+				result.Transactions = append(result.Transactions, TransactionVerification{TransactionID: transactionID, Status: verificationVerified})
+			}
+			result.FullyVerified += len(transactionIDs)
+			return nil, nil, nil
+		}
+		color.Yellow("  ⚠ History digest differs from the server, comparing transaction digests")
+
+		if serverDigests, err = getServerTransactionDigests(client, machineId, hostname); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var differing []string
+	differingDetails := make(map[string]TransactionDetail)
+	for _, transactionID := range transactionIDs {
+		digest, read := localDigests[transactionID]
+		if read && digest == serverDigests[transactionID] {
+			result.Transactions = append(result.Transactions, TransactionVerification{TransactionID: transactionID, Status: verificationVerified})
+			result.FullyVerified++
+			continue
+		}
+		differing = append(differing, transactionID)
+		if details, ok := readDetails[transactionID]; ok {
+			differingDetails[transactionID] = details
+		}
+	}
+
+	if len(differing) > 0 {
+		color.Yellow("  ⚠ %d transaction digest(s) differ from the server, comparing them in depth", len(differing))
+	} else {
+		color.Green("  ✓ All transaction digests match the server")
+	}
+	return differing, differingDetails, nil
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestTransactionDigest(t *testing.T) {
+	vim := Package{Action: "Install", Name: "vim", Version: "8.2", Release: "1.el8", Arch: "x86_64", Repo: "appstream"}
+	git := Package{Action: "Install", Name: "git", Version: "2.31", Release: "1.el8", Arch: "x86_64", Repo: "appstream"}
+	details := TransactionDetail{
+		TransactionID:   "7",
+		BeginTime:       "2024-03-10T12:00:00+02:00",
+		EndTime:         "2024-03-10T12:00:05+02:00",
+		User:            "root <root>",
+		ReturnCode:      "Success",
+		Releasever:      "9",
+		CommandLine:     "install vim git",
+		PackagesAltered: []Package{vim, git},
+	}
+	digest := transactionDigest(details)
+
+	same := details
+	same.BeginTime = "2024-03-10T10:00:00Z"
+	same.User = " root <root>"
+	same.PackagesAltered = []Package{git, vim, git}
+	if transactionDigest(same) != digest {
+		t.Error("transactionDigest() should not depend on time zones, whitespace, item order or duplicates")
+	}
+
+	changes := map[string]func(d *TransactionDetail){
+		"begin time": func(d *TransactionDetail) { d.BeginTime = "2024-03-10T13:00:00+02:00" },
+		"user":       func(d *TransactionDetail) { d.User = "admin <admin>" },
+		"items":      func(d *TransactionDetail) { d.PackagesAltered = []Package{vim} },
+		"ID":         func(d *TransactionDetail) { d.TransactionID = "8" },
+	}
+	for name, change := range changes {
+		changed := details
+		change(&changed)
+		if transactionDigest(changed) == digest {
+			t.Errorf("transactionDigest() should change with the %s", name)
+		}
+	}
+}
+
+func TestHistoryDigest(t *testing.T) {
+	if root := historyDigest(nil); root != "" {
+		t.Errorf("historyDigest() = %q for an empty history, want an empty string", root)
+	}
+
+	root := historyDigest([]string{"a", "b", "c"})
+	if root != historyDigest([]string{"a", "b", "c"}) {
+		t.Error("historyDigest() should be stable")
+	}
+	if root == historyDigest([]string{"b", "a", "c"}) {
+		t.Error("historyDigest() should depend on the order of the transactions")
+	}
+	if root == historyDigest([]string{"a", "b"}) {
+		t.Error("historyDigest() should change when a transaction is removed")
+	}
+}
+
+func TestVerifyTransactions_Digest(t *testing.T) {
+	vim := Package{Action: "Install", Name: "vim", Version: "8.2", Release: "1.el8", Arch: "x86_64", Repo: "appstream"}
+	git := Package{Action: "Install", Name: "git", Version: "2.31", Release: "1.el8", Arch: "x86_64", Repo: "appstream"}
+
+	source := &itemsHistorySource{items: map[string][]Package{"1": {vim}, "2": {vim, git}, "3": {git}}}
+	for _, id := range []string{"3", "2", "1"} {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: id})
+	}
+
+	// The server lost git in transaction 2
+	serverItems := map[string][]Package{"1": {vim}, "2": {vim}, "3": {git}}
+	serverDigests := []serverTransactionDigest{}
+	var rootDigests []string
+	for _, id := range []string{"1", "2", "3"} {
+		digest := transactionDigest(TransactionDetail{TransactionID: id, PackagesAltered: serverItems[id]})
+		serverDigests = append(serverDigests, serverTransactionDigest{TransactionID: id, Digest: digest})
+		rootDigests = append(rootDigests, digest)
+	}
+
+	tests := []struct {
+		name         string
+		digests      bool
+		root         string
+		scope        verifyScope
+		wantItems    []string
+		wantVerified int
+		wantRead     int
+	}{
+		{"history digest matches", true, historyDigest([]string{
+			transactionDigest(TransactionDetail{TransactionID: "1", PackagesAltered: []Package{vim}}),
+			transactionDigest(TransactionDetail{TransactionID: "2", PackagesAltered: []Package{vim, git}}),
+			transactionDigest(TransactionDetail{TransactionID: "3", PackagesAltered: []Package{git}}),
+		}), verifyScope{}, nil, 3, 3},
+		// Each transaction is read once, with or without the history digest
+		{"transaction digest differs", true, historyDigest(rootDigests), verifyScope{}, []string{"2"}, 2, 3},
+		{"transaction digest differs in scope", true, "", verifyScope{fromID: 1}, []string{"2"}, 2, 3},
+		{"server without digests", false, "", verifyScope{}, []string{"1", "2", "3"}, 2, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var itemRequests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if strings.HasPrefix(r.URL.Path, "/v1/transactions/digest") && !tt.digests {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				switch r.URL.Path {
+				case "/v1/transactions/ids":
+					json.NewEncoder(w).Encode([]int{1, 2, 3})
+				case "/v1/transactions/digest":
+					json.NewEncoder(w).Encode(serverHistoryDigest{Version: digestVersion, Count: 3, Root: tt.root})
+				case "/v1/transactions/digests":
+					json.NewEncoder(w).Encode(map[string]interface{}{"version": digestVersion, "transactions": serverDigests})
+				case "/v1/items":
+					id := r.URL.Query().Get("transaction_id")
+					mu.Lock()
+					itemRequests = append(itemRequests, id)
+					mu.Unlock()
+					json.NewEncoder(w).Encode(ServerTransaction{Items: serverItems[id]})
+				}
+			}))
+			defer server.Close()
+
+			viper.Reset()
+			viper.Set("server.url", server.URL)
+			defer viper.Reset()
+
+			source.read.Store(0)
+			result, err := verifyTransactions(source, "machine", "host", verifyOptions{digest: true, scope: tt.scope})
+			if err != nil {
+				t.Fatalf("verifyTransactions() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(itemRequests, tt.wantItems) {
+				t.Errorf("items requested for %v, want %v", itemRequests, tt.wantItems)
+			}
+			if read := int(source.read.Load()); read != tt.wantRead {
+				t.Errorf("%d transactions read, want %d", read, tt.wantRead)
+			}
+			if result.FullyVerified != tt.wantVerified || len(result.Transactions) != 3 {
+				t.Errorf("result = %d fully verified in %d transactions, want %d in 3", result.FullyVerified, len(result.Transactions), tt.wantVerified)
+			}
+			if tt.wantVerified < 3 && !reflect.DeepEqual(result.WithMissingItems, []string{"2"}) {
+				t.Errorf("WithMissingItems = %v, want transaction 2", result.WithMissingItems)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// dryRunBuild is the end of 'txlog build --dry-run', once the transactions
+// saved on the server are known: it prints the transactions and the
+// execution report that would be sent, and returns the exit code of the build.
+// When payloads is not nil, their JSON bodies are written to it, see
+// previewUnsentTransactions.
+func dryRunBuild(source HistorySource, machineId, hostname string, savedSet map[string]struct{}, concurrency int, payloads io.Writer) int {
+	if stats, err := spoolStats(); err == nil && stats.Count > 0 {
+		fmt.Fprintf(color.Output, "📤 %s spooled payloads would be replayed first\n\n", color.YellowString("%d", stats.Count))
+	}
+
+	fmt.Fprintf(color.Output, "⚙️  Compiling transaction data (dry run)...\n")
+	processed, toSend, failures, err := previewUnsentTransactions(source, machineId, hostname, savedSet, concurrency, uploadBatchSize(), payloads)
+	if err != nil {
+		color.Red("✗ Error retrieving transactions: %v", err)
+		return 1
+	}
+
+	details := ""
+	if len(failures) > 0 {
+		details = failuresSummary(failures)
+	}
+	body, err := executionPayload(len(failures) == 0, machineId, hostname, details, processed, toSend, len(failures))
+	if err != nil {
+		color.Yellow("⚠ Warning: failed to build execution report: %v", err)
+	} else {
+		fmt.Fprintf(color.Output, "   → Execution report would be sent\n")
+		if err := writePayload(payloads, body); err != nil {
+			color.Red("✗ Error printing execution report: %v", err)
+			return 1
+		}
+	}
+
+	fmt.Fprintln(color.Output)
+	fmt.Fprintln(color.Output, strings.Repeat("=", 60))
+	color.Cyan("ℹ Dry run completed - nothing was sent")
+	fmt.Fprintf(color.Output, "   Transactions processed: %s\n", color.CyanString("%d", processed))
+	fmt.Fprintf(color.Output, "   Transactions to send:   %s\n", color.GreenString("%d", toSend))
+	if len(failures) > 0 {
+		fmt.Fprintf(color.Output, "   Transactions failed:    %s\n", color.RedString("%d", len(failures)))
+	}
+	fmt.Fprintln(color.Output, strings.Repeat("=", 60))
+	fmt.Fprintln(color.Output)
+
+	if len(failures) > 0 {
+		return exitPartialFailure
+	}
+	return 0
+}
+
+// previewUnsentTransactions reads the transactions not in savedSet the same
+// way saveUnsentTransactions does, and prints the ones that would be sent
+// instead of sending them. When payloads is not nil, the JSON body of each
+// transaction is written to it, on its own line, as sent for a single
+// transaction. Batch and chunk requests are not shown: a batch is a JSON
+// array of these bodies, and chunks split one of them.
+//
+// Returns:
+//   - int: total number of entries processed, including the failed ones
+//   - int: number of entries that would be sent to the server
+//   - []transactionFailure: transactions that could not be read, in ascending order
+//   - error: any error encountered during execution
+func previewUnsentTransactions(source HistorySource, machineId, hostname string, savedSet map[string]struct{}, concurrency, batchSize int, payloads io.Writer) (int, int, []transactionFailure, error) {
+	entries, err := source.Entries()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	unsent, unsentIDs := unsentTransactions(entries, savedSet)
+	entriesProcessed := len(entries) - len(unsent)
+	reader := newTransactionReader(source, unsentIDs)
+
+	if len(unsent) > 0 {
+		batchSize = max(batchSize, 1)
+		requests := (len(unsent) + batchSize - 1) / batchSize
+		if batchSize > 1 {
+			fmt.Fprintf(color.Output, "   %s transactions would be sent in %d compressed batches of up to %d\n", color.YellowString("%d", len(unsent)), requests, batchSize)
+		} else {
+			fmt.Fprintf(color.Output, "   %s transactions would be sent, one request each\n", color.YellowString("%d", len(unsent)))
+		}
+	}
+
+	entriesToSend := 0
+	var failures []transactionFailure
+	err = runOrdered(concurrency, len(unsent), func(i int) transactionUpload {
+		details, err := reader.read(i)
+		if err != nil {
+			return transactionUpload{err: err}
+		}
+		return transactionUpload{body: transactionPayload(unsent[i], details, machineId, hostname)}
+	}, func(i int, result transactionUpload) error {
+		transactionID := unsent[i].TransactionID
+		entriesProcessed++
+		if result.err != nil {
+			failures = append(failures, transactionFailure{TransactionID: transactionID, Err: result.err})
+			color.Red("   ✗ Transaction #%s failed: %v", transactionID, result.err)
+			return nil
+		}
+
+		entriesToSend++
+		color.Cyan("   → Transaction #%s would be sent (%s, %v packages)", transactionID, result.body["actions"], result.body["altered"])
+		return writePayload(payloads, result.body)
+	})
+
+	return entriesProcessed, entriesToSend, failures, err
+}
+
+// writePayload writes the JSON encoding of body on its own line, as sent to
+// the server. Nothing is written when w is nil.
+func writePayload(w io.Writer, body interface{}) error {
+	if w == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPreviewUnsentTransactions(t *testing.T) {
+	source := &failingHistorySource{failing: map[string]bool{"4": true}}
+	for i := 1; i <= 5; i++ {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: strconv.Itoa(i), Actions: "Install", Altered: "1"})
+	}
+	savedSet := map[string]struct{}{"1": {}, "2": {}}
+
+	var payloads bytes.Buffer
+	processed, toSend, failures, err := previewUnsentTransactions(source, "machine", "host", savedSet, 2, 1, &payloads)
+	if err != nil {
+		t.Fatalf("previewUnsentTransactions() error = %v", err)
+	}
+	if processed != 5 || toSend != 2 {
+		t.Errorf("previewUnsentTransactions() = %d processed, %d to send; want 5 and 2", processed, toSend)
+	}
+	if len(failures) != 1 || failures[0].TransactionID != "4" {
+		t.Errorf("failures = %v, want transaction 4", failures)
+	}
+
+	lines := strings.Split(strings.TrimSpace(payloads.String()), "\n")
+	want := []string{"3", "5"}
+	if len(lines) != len(want) {
+		t.Fatalf("payloads = %q, want one line per transaction to send", payloads.String())
+	}
+	for i, line := range lines {
+		var body map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &body); err != nil {
+			t.Fatalf("payload %d is not JSON: %v", i, err)
+		}
+		if body["transaction_id"] != want[i] || body["machine_id"] != "machine" || body["hostname"] != "host" {
+			t.Errorf("payload %d = %v, want transaction %s of machine/host", i, body, want[i])
+		}
+	}
+}
+
+func TestPreviewUnsentTransactions_WithoutPayloads(t *testing.T) {
+	source := &stubHistorySource{entries: []HistoryEntry{{TransactionID: "1"}}}
+
+	processed, toSend, failures, err := previewUnsentTransactions(source, "machine", "host", map[string]struct{}{}, 1, 50, nil)
+	if err != nil || processed != 1 || toSend != 1 || len(failures) != 0 {
+		t.Errorf("previewUnsentTransactions() = %d, %d, %v, %v; want 1, 1, none, nil", processed, toSend, failures, err)
+	}
+}
+
+func TestWritePayload(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writePayload(&buf, map[string]interface{}{"b": 1, "a": "x"}); err != nil {
+		t.Fatalf("writePayload() error = %v", err)
+	}
+	if buf.String() != `{"a":"x","b":1}`+"\n" {
+		t.Errorf("writePayload() wrote %q", buf.String())
+	}
+
+	if err := writePayload(nil, map[string]interface{}{}); err != nil {
+		t.Errorf("writePayload() error = %v with a nil writer", err)
+	}
+}
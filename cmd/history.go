@@ -0,0 +1,363 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/txlog/agent/util"
+)
+
+// HistorySource provides the local transaction history. It is shared by the
+// build and verify commands, so both always agree on what the local history is.
+type HistorySource interface {
+	// Entries lists all transactions in ascending order of ID.
+	Entries() ([]HistoryEntry, error)
+	// Transaction returns the details of a single transaction.
+	Transaction(transactionID string) (TransactionDetail, error)
+	// Close releases any resource held by the source.
+	Close() error
+}
+
+// batchHistorySource is implemented by the sources that read the details of
+// many transactions faster with a single command than with one command per
+// transaction.
+type batchHistorySource interface {
+	// Transactions returns the details of the given transactions that could be
+	// read at once, by transaction ID. The result may be partial even when an
+	// error is returned.
+	Transactions(transactionIDs []string) (map[string]TransactionDetail, error)
+}
+
+// latestHistorySource is implemented by the sources that can tell the ID of
+// the latest transaction without listing the whole history.
+type latestHistorySource interface {
+	// LatestTransactionID returns the ID of the latest transaction, or an
+	// empty string if the history is empty.
+	LatestTransactionID() (string, error)
+}
+
+// latestTransactionID returns the ID of the latest transaction of source, or
+// an empty string if the history is empty.
+func latestTransactionID(source HistorySource) (string, error) {
+	if latest, ok := source.(latestHistorySource); ok {
+		return latest.LatestTransactionID()
+	}
+
+	entries, err := source.Entries()
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+	return entries[len(entries)-1].TransactionID, nil
+}
+
+// historyBatchSize is the number of transactions read at once from sources
+// implementing batchHistorySource.
+const historyBatchSize = 50
+
+// HistoryEntry represents a single row of the transaction history list, as
+// shown in the 'dnf history list' command.
+type HistoryEntry struct {
+	TransactionID string
+	Actions       string
+	Altered       string
+}
+
+// eachTransaction calls fn with the details of each transaction in
+// transactionIDs, in that order. Sources implementing batchHistorySource are
+// read historyBatchSize transactions at a time; a transaction missing from a
+// batch is read on its own, so that the error reading it, if any, is passed to
+// fn. eachTransaction stops at the first error returned by fn.
+func eachTransaction(source HistorySource, transactionIDs []string, fn func(transactionID string, transaction TransactionDetail, err error) error) error {
+	reader := newTransactionReader(source, transactionIDs)
+
+	for i, transactionID := range transactionIDs {
+		transaction, err := reader.read(i)
+		if err := fn(transactionID, transaction, err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// transactionReader reads the details of a list of transactions by index,
+// historyBatchSize transactions at a time for sources implementing
+// batchHistorySource. It is safe for concurrent use, so that workers reading
+// neighbouring transactions share the batch holding them.
+type transactionReader struct {
+	source         HistorySource
+	batcher        batchHistorySource
+	transactionIDs []string
+	batches        []transactionBatch
+}
+
+// transactionBatch holds the details read in a batch until they are used.
+type transactionBatch struct {
+	once  sync.Once
+	mu    sync.Mutex
+	found map[string]TransactionDetail
+}
+
+// newTransactionReader returns a reader of the given transactions.
+func newTransactionReader(source HistorySource, transactionIDs []string) *transactionReader {
+	reader := &transactionReader{source: source, transactionIDs: transactionIDs}
+	if batcher, ok := source.(batchHistorySource); ok {
+		reader.batcher = batcher
+		reader.batches = make([]transactionBatch, (len(transactionIDs)+historyBatchSize-1)/historyBatchSize)
+	}
+	return reader
+}
+
+// read returns the details of the i-th transaction. The batch holding it is
+// read on first use; a transaction missing from its batch is read on its own.
+func (r *transactionReader) read(i int) (TransactionDetail, error) {
+	transactionID := r.transactionIDs[i]
+
+	if r.batcher != nil {
+		start := i - i%historyBatchSize
+		batch := r.transactionIDs[start:min(start+historyBatchSize, len(r.transactionIDs))]
+
+		if len(batch) > 1 {
+			b := &r.batches[i/historyBatchSize]
+			b.once.Do(func() {
+				// A failed batch is not fatal, the transactions it did not
+				// return are read one at a time
+				b.found, _ = r.batcher.Transactions(batch)
+			})
+
+			b.mu.Lock()
+			transaction, ok := b.found[transactionID]
+			delete(b.found, transactionID)
+			b.mu.Unlock()
+			if ok {
+				return transaction, nil
+			}
+		}
+	}
+
+	return r.source.Transaction(transactionID)
+}
+
+// knownHistorySource is a HistorySource returning the details already read
+// of some transactions instead of reading them again. Each of them is
+// returned once, then read from the underlying source, so that they are not
+// kept in memory longer than needed.
+type knownHistorySource struct {
+	HistorySource
+
+	mu    sync.Mutex
+	known map[string]TransactionDetail
+}
+
+// withKnownTransactions returns source returning the details in known, by
+// transaction ID, instead of reading them.
+func withKnownTransactions(source HistorySource, known map[string]TransactionDetail) *knownHistorySource {
+	if known == nil {
+		known = make(map[string]TransactionDetail)
+	}
+	return &knownHistorySource{HistorySource: source, known: known}
+}
+
+// add records the details of transactions read elsewhere.
+func (s *knownHistorySource) add(known map[string]TransactionDetail) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for transactionID, details := range known {
+		s.known[transactionID] = details
+	}
+}
+
+// take returns and forgets the known details of a transaction.
+func (s *knownHistorySource) take(transactionID string) (TransactionDetail, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	details, found := s.known[transactionID]
+	delete(s.known, transactionID)
+	return details, found
+}
+
+func (s *knownHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	if details, found := s.take(transactionID); found {
+		return details, nil
+	}
+	return s.HistorySource.Transaction(transactionID)
+}
+
+// Transactions returns the known transactions, and reads the others at once
+// when the underlying source supports it.
+func (s *knownHistorySource) Transactions(transactionIDs []string) (map[string]TransactionDetail, error) {
+	found := make(map[string]TransactionDetail, len(transactionIDs))
+	var unknown []string
+	for _, transactionID := range transactionIDs {
+		if details, ok := s.take(transactionID); ok {
+			found[transactionID] = details
+		} else {
+			unknown = append(unknown, transactionID)
+		}
+	}
+
+	batcher, ok := s.HistorySource.(batchHistorySource)
+	if !ok || len(unknown) == 0 {
+		return found, nil
+	}
+	read, err := batcher.Transactions(unknown)
+	for transactionID, details := range read {
+		found[transactionID] = details
+	}
+	return found, err
+}
+
+// defaultHistorySource is used when neither the --source flag nor the
+// agent.history_source setting are set.
+const defaultHistorySource = "auto"
+
+// historySourceUsage documents the accepted values of the --source flag.
+const historySourceUsage = `history source: auto, cli, dnf5, sqlite[:path], yum[:dir] or fixtures:dir`
+
+// addHistorySourceFlag registers the --source flag on a command.
+func addHistorySourceFlag(cmd *cobra.Command) {
+	cmd.Flags().String("source", "", historySourceUsage+" (default is agent.history_source or \"auto\")")
+}
+
+// historySourceSpec returns the history source selected by the --source flag,
+// falling back to the agent.history_source setting and then to "auto".
+func historySourceSpec(cmd *cobra.Command) string {
+	if source, _ := cmd.Flags().GetString("source"); source != "" {
+		return source
+	}
+	if source := viper.GetString("agent.history_source"); source != "" {
+		return source
+	}
+	return defaultHistorySource
+}
+
+// openHistorySource creates the HistorySource described by spec, which has
+// the form "kind" or "kind:path":
+//   - auto: detects the best source for this host (see autoHistorySource)
+//   - cli: parses the output of 'dnf history' (or 'yum history')
+//   - dnf5: parses the JSON output of 'dnf5 history'
+//   - sqlite[:path]: reads a DNF history database
+//   - yum[:dir]: reads the most recent yum history database in a directory
+//   - fixtures:dir: reads recorded command outputs from a directory
+func openHistorySource(spec string) (HistorySource, error) {
+	kind, path, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case "", "auto":
+		return autoHistorySource(), nil
+	case "cli":
+		return &cliHistorySource{}, nil
+	case "dnf5":
+		return &dnf5HistorySource{}, nil
+	case "sqlite":
+		if path == "" {
+			path = dnfHistoryDBPath
+		}
+		source, err := newDNFDBHistorySource(path)
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	case "yum":
+		if path == "" {
+			path = yumHistoryDir
+		}
+		source, err := newYumDBHistorySource(path)
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	case "fixtures":
+		source, err := newFixturesHistorySource(path)
+		if err != nil {
+			return nil, err
+		}
+		return source, nil
+	}
+
+	return nil, fmt.Errorf("unknown history source %q, expected %s", spec, historySourceUsage)
+}
+
+// autoHistorySource selects the best history source for this host: dnf5 JSON
+// output on dnf5 hosts, otherwise the DNF or yum history database, with the
+// command line as a fallback when the database cannot be read.
+func autoHistorySource() HistorySource {
+	if util.IsDNF5() {
+		return &dnf5HistorySource{}
+	}
+
+	if source, err := newDNFDBHistorySource(dnfHistoryDBPath); err == nil {
+		return &fallbackHistorySource{primary: source, fallback: &cliHistorySource{}}
+	}
+
+	if source, err := newYumDBHistorySource(yumHistoryDir); err == nil {
+		return &fallbackHistorySource{primary: source, fallback: &cliHistorySource{}}
+	}
+
+	return &cliHistorySource{}
+}
+
+// fallbackHistorySource queries a primary source and retries with the
+// fallback source whenever the primary one fails.
+type fallbackHistorySource struct {
+	primary  HistorySource
+	fallback HistorySource
+}
+
+// Entries implements HistorySource.
+func (s *fallbackHistorySource) Entries() ([]HistoryEntry, error) {
+	if entries, err := s.primary.Entries(); err == nil {
+		return entries, nil
+	}
+	return s.fallback.Entries()
+}
+
+// Transaction implements HistorySource.
+func (s *fallbackHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	if transaction, err := s.primary.Transaction(transactionID); err == nil {
+		return transaction, nil
+	}
+	return s.fallback.Transaction(transactionID)
+}
+
+// LatestTransactionID implements latestHistorySource.
+func (s *fallbackHistorySource) LatestTransactionID() (string, error) {
+	if transactionID, err := latestTransactionID(s.primary); err == nil {
+		return transactionID, nil
+	}
+	return latestTransactionID(s.fallback)
+}
+
+// Close implements HistorySource.
+func (s *fallbackHistorySource) Close() error {
+	primaryErr := s.primary.Close()
+	if err := s.fallback.Close(); err != nil {
+		return err
+	}
+	return primaryErr
+}
+
+// openSQLiteReadOnly opens a SQLite database in read-only mode. It returns an
+// error if the database does not exist or cannot be read.
+func openSQLiteReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,347 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/txlog/agent/util"
+)
+
+// Pre-compiled regexes for parsing DNF history output
+var (
+	reHistoryLine      = regexp.MustCompile(`\s*(\d+)\s*\|\s*(.+?)\s*\|\s*(.+?)\s*\|\s*(.+?)\s*\|\s*(\d+)`)
+	reTransactionField = regexp.MustCompile(`^(.+?)\s*:\s*(.+)$`)
+	rePackageInstall   = regexp.MustCompile(`^\s+(?:\*\*\s*)?([\w-]+(?: Change)?)\s+(.+?)\s+@(.+)$`)
+	rePackageUpgraded  = regexp.MustCompile(`^\s+(?:\*\*\s*)?([\w-]+(?: Change)?)\s+(.+?)\s+@@(.+)$`)
+	// Each scriptlet output and error line is prefixed with its number, as in "%4d %s"
+	reScriptletOutput = regexp.MustCompile(`^\s*\d+ (.*)$`)
+)
+
+// cliHistorySource reads the transaction history by parsing the output of
+// the 'dnf history' (or 'yum history') command line.
+type cliHistorySource struct{}
+
+// Entries implements HistorySource.
+func (s *cliHistorySource) Entries() ([]HistoryEntry, error) {
+	cmd := exec.Command(util.PackageBinary(), "history", "--reverse", "list")
+	util.SetCLocale(cmd)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseHistoryList(string(out))
+}
+
+// Transaction implements HistorySource.
+func (s *cliHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	if !reValidInput.MatchString(transactionID) {
+		return TransactionDetail{}, fmt.Errorf("invalid input")
+	}
+	cmd := exec.Command(util.PackageBinary(), "history", "info", transactionID)
+	util.SetCLocale(cmd)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+
+	transaction, err := parseHistoryInfo(string(out))
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+	if transaction.TransactionID != transactionID {
+		return TransactionDetail{}, fmt.Errorf("requested transaction %s, but the output describes transaction %s", transactionID, transaction.TransactionID)
+	}
+
+	return transaction, nil
+}
+
+// Transactions implements batchHistorySource. dnf prints the details of every
+// transaction given on its command line one after the other, which are split
+// at their "Transaction ID" field and parsed as they are read. Ranges (as in
+// 1..50) are not used, as dnf merges a range into a single transaction, and
+// nothing is read in batches from yum, which merges any list of transactions.
+func (s *cliHistorySource) Transactions(transactionIDs []string) (map[string]TransactionDetail, error) {
+	binary := util.PackageBinary()
+	if binary != "dnf" {
+		return nil, nil
+	}
+	for _, transactionID := range transactionIDs {
+		if !reValidInput.MatchString(transactionID) {
+			return nil, fmt.Errorf("invalid input")
+		}
+	}
+
+	cmd := exec.Command(binary, append([]string{"history", "info"}, transactionIDs...)...)
+	util.SetCLocale(cmd)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	transactions := make(map[string]TransactionDetail, len(transactionIDs))
+	parseErr := parseHistoryInfoStream(stdout, func(transaction TransactionDetail) {
+		transactions[transaction.TransactionID] = transaction
+	})
+
+	return transactions, errors.Join(parseErr, cmd.Wait())
+}
+
+// Close implements HistorySource.
+func (s *cliHistorySource) Close() error {
+	return nil
+}
+
+// parseHistoryList parses the output of 'dnf history list' and returns its
+// entries in ascending order of ID, whichever order they were printed in. It
+// returns an error if a table row cannot be parsed, rather than silently
+// leaving the transaction out.
+func parseHistoryList(output string) ([]HistoryEntry, error) {
+	lines := strings.Split(output, "\n")
+	if len(lines) < 3 {
+		return nil, nil
+	}
+	lines = lines[2:]
+
+	entries := make([]HistoryEntry, 0, len(lines))
+	for _, line := range lines {
+		if matches := reHistoryLine.FindStringSubmatch(line); matches != nil {
+			entries = append(entries, HistoryEntry{
+				TransactionID: strings.TrimSpace(matches[1]),
+				Actions:       strings.TrimSpace(matches[4]),
+				Altered:       strings.TrimSpace(matches[5]),
+			})
+		} else if strings.Contains(line, "|") {
+			return nil, fmt.Errorf("unable to parse history list line: %q", strings.TrimSpace(line))
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, _ := strconv.Atoi(entries[i].TransactionID)
+		b, _ := strconv.Atoi(entries[j].TransactionID)
+		return a < b
+	})
+
+	return entries, nil
+}
+
+// parseHistoryInfoStream parses the output of 'dnf history info' for several
+// transactions as it is read, calling fn for each transaction. A transaction
+// that cannot be parsed is skipped and the first such error is returned once
+// the whole output has been read.
+func parseHistoryInfoStream(r io.Reader, fn func(TransactionDetail)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxScriptletBytes+64*1024)
+
+	var block strings.Builder
+	var firstErr error
+	flush := func() {
+		if strings.TrimSpace(block.String()) == "" {
+			return
+		}
+		transaction, err := parseHistoryInfo(block.String())
+		if err != nil && firstErr == nil {
+			firstErr = err
+		} else if err == nil {
+			fn(transaction)
+		}
+		block.Reset()
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		// dnf prints a line of dashes between the transactions
+		if historySeparator(line) {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, "Transaction ID") {
+			flush()
+		}
+		block.WriteString(line)
+		block.WriteByte('\n')
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// historySeparator reports whether line is made only of dashes, as printed
+// by 'dnf history info' between transactions.
+func historySeparator(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && strings.Trim(line, "-") == ""
+}
+
+// Sections of the output of 'dnf history info' that follow the transaction
+// fields. Sections that are not parsed, such as "Transaction performed with",
+// are skipped, so their lines are not mistaken for altered packages.
+const (
+	historySectionFields = iota
+	historySectionPackages
+	historySectionScriptlet
+	historySectionErrors
+	historySectionSkipped
+)
+
+// historySections maps the section titles printed by dnf and yum to the
+// sections above.
+var historySections = map[string]int{
+	"Packages Altered":           historySectionPackages,
+	"Scriptlet output":           historySectionScriptlet,
+	"Errors":                     historySectionErrors,
+	"Transaction performed with": historySectionSkipped,
+	"Packages Skipped":           historySectionSkipped,
+	"Rpmdb Problems":             historySectionSkipped,
+}
+
+// parseHistoryInfo parses the output of 'dnf history info' for a single
+// transaction. The output is expected in the C locale: if any of the fields
+// that dnf and yum always print is missing, or the package list is present but
+// none of its lines could be parsed, the output is rejected instead of
+// producing a half-empty transaction. The Return-Code is not printed for a
+// transaction that is still running, which is then incomplete.
+func parseHistoryInfo(output string) (TransactionDetail, error) {
+	lines := strings.Split(output, "\n")
+
+	var transaction TransactionDetail
+	var packages []Package
+	var scriptletOutput, errorLines []string
+	hasPackageSection := false
+	section := historySectionFields
+	for _, line := range lines {
+		if title, ok := strings.CutSuffix(strings.TrimSpace(line), ":"); ok && !strings.HasPrefix(line, " ") {
+			if next, ok := historySections[title]; ok {
+				section = next
+				hasPackageSection = hasPackageSection || section == historySectionPackages
+				continue
+			}
+		}
+
+		switch section {
+		case historySectionPackages:
+			// The replaced side of an upgrade or downgrade is printed with
+			// "@@", followed by the repository it came from
+			matches := rePackageUpgraded.FindStringSubmatch(line)
+			fromRepo := matches != nil
+			if !fromRepo {
+				matches = rePackageInstall.FindStringSubmatch(line)
+			}
+			if matches != nil {
+				name, version, release, epoch, arch := util.SplitPackageName(strings.TrimSpace(matches[2]))
+				pkg := Package{
+					Action:  strings.TrimSpace(matches[1]),
+					Name:    name,
+					Version: version,
+					Release: release,
+					Epoch:   epoch,
+					Arch:    arch,
+				}
+				if fromRepo {
+					pkg.FromRepo = strings.TrimSpace(matches[3])
+				} else {
+					pkg.Repo = strings.TrimSpace(matches[3])
+				}
+				// 'yum history info' prints the yum state, which also tells
+				// whether a package was installed as a dependency
+				pkg.Reason = yumItemReasons[pkg.Action]
+				if a, ok := yumItemStates[pkg.Action]; ok {
+					pkg.Action = a.name
+				}
+				packages = append(packages, pkg)
+			}
+			continue
+		case historySectionScriptlet:
+			if matches := reScriptletOutput.FindStringSubmatch(line); matches != nil {
+				scriptletOutput = append(scriptletOutput, matches[1])
+			} else if strings.TrimSpace(line) != "" {
+				scriptletOutput = append(scriptletOutput, strings.TrimSpace(line))
+			}
+			continue
+		case historySectionErrors:
+			if matches := reScriptletOutput.FindStringSubmatch(line); matches != nil {
+				errorLines = append(errorLines, matches[1])
+			} else if strings.TrimSpace(line) != "" {
+				errorLines = append(errorLines, strings.TrimSpace(line))
+			}
+			continue
+		case historySectionSkipped:
+			continue
+		}
+
+		if matches := reTransactionField.FindStringSubmatch(line); matches != nil {
+			key := strings.TrimSpace(matches[1])
+			value := strings.TrimSpace(matches[2])
+			switch key {
+			case "Transaction ID":
+				transaction.TransactionID = value
+			case "Begin time":
+				beginTime, err := util.DateConversion(value)
+				if err != nil {
+					return TransactionDetail{}, err
+				}
+				transaction.BeginTime = beginTime
+			case "Begin rpmdb":
+				transaction.BeginRPMDB = value
+			case "End time":
+				endTime, err := util.DateConversion(strings.Split(value, " (")[0])
+				if err != nil {
+					return TransactionDetail{}, err
+				}
+				transaction.EndTime = endTime
+			case "End rpmdb":
+				transaction.EndRPMDB = value
+			case "User":
+				transaction.User = value
+			case "Return-Code":
+				transaction.ReturnCode = value
+			case "Releasever":
+				transaction.Releasever = value
+			case "Command Line":
+				transaction.CommandLine = value
+			case "Comment":
+				transaction.Comment = value
+			}
+		}
+	}
+
+	var missing []string
+	if transaction.TransactionID == "" {
+		missing = append(missing, "Transaction ID")
+	}
+	if transaction.BeginTime == "" {
+		missing = append(missing, "Begin time")
+	}
+	if transaction.User == "" {
+		missing = append(missing, "User")
+	}
+	if hasPackageSection && len(packages) == 0 {
+		missing = append(missing, "Packages Altered")
+	}
+	if len(missing) > 0 {
+		return TransactionDetail{}, fmt.Errorf("incomplete history info output, unable to parse: %s", strings.Join(missing, ", "))
+	}
+
+	transaction.PackagesAltered = packages
+	transaction.ScriptletOutput = scriptletOutput
+	transaction.Errors = errorLines
+	completeTransactionDetail(&transaction)
+
+	return transaction, nil
+}
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseHistoryInfo(t *testing.T) {
+	data, err := os.ReadFile("testdata/dnf4/3.txt")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	transaction, err := parseHistoryInfo(string(data))
+	if err != nil {
+		t.Fatalf("parseHistoryInfo() error = %v", err)
+	}
+
+	if transaction.TransactionID != "3" {
+		t.Errorf("TransactionID = %q, want %q", transaction.TransactionID, "3")
+	}
+	if transaction.User != "root <root>" {
+		t.Errorf("User = %q, want %q", transaction.User, "root <root>")
+	}
+	if transaction.ReturnCode != "Success" {
+		t.Errorf("ReturnCode = %q, want %q", transaction.ReturnCode, "Success")
+	}
+	if len(transaction.PackagesAltered) != 4 {
+		t.Fatalf("PackagesAltered has %d items, want 4", len(transaction.PackagesAltered))
+	}
+	if upgraded := transaction.PackagesAltered[1]; upgraded.Repo != "" || upgraded.FromRepo != "System" {
+		t.Errorf("Upgraded package has Repo %q and FromRepo %q, want the @@ repository in FromRepo", upgraded.Repo, upgraded.FromRepo)
+	}
+
+	wantChange := PackageChange{
+		Action:   "Upgrade",
+		Name:     "openssl",
+		From:     "openssl-1:3.0.7-24.el9.x86_64",
+		To:       "openssl-1:3.0.7-27.el9.x86_64",
+		FromRepo: "System",
+		ToRepo:   "baseos",
+	}
+	if len(transaction.PackageChanges) != 2 || transaction.PackageChanges[0] != wantChange {
+		t.Errorf("PackageChanges = %+v, want [%+v ...]", transaction.PackageChanges, wantChange)
+	}
+	wantScriptlet := ScriptletLine{
+		Level: "warning",
+		Text:  "warning: /etc/pki/tls/openssl.cnf created as /etc/pki/tls/openssl.cnf.rpmnew",
+	}
+	if len(transaction.Scriptlets) != 1 || transaction.Scriptlets[0] != wantScriptlet {
+		t.Errorf("Scriptlets = %+v, want [%+v]", transaction.Scriptlets, wantScriptlet)
+	}
+}
+
+func TestParseHistoryInfo_Localized(t *testing.T) {
+	data, err := os.ReadFile("testdata/localized/3.txt")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	_, err = parseHistoryInfo(string(data))
+	if err == nil {
+		t.Fatal("expected an error for output in a non-English locale")
+	}
+	if !strings.Contains(err.Error(), "Transaction ID") {
+		t.Errorf("error = %q, want it to mention the missing fields", err)
+	}
+}
+
+func TestParseHistoryInfo_UnparsedPackages(t *testing.T) {
+	output := `Transaction ID : 3
+Begin time     : Tue 05 Mar 2024 10:12:01 AM UTC
+User           : root <root>
+Return-Code    : Success
+Packages Altered:
+    Atualizar  openssl-1:3.0.7-27.el9.x86_64
+`
+
+	if _, err := parseHistoryInfo(output); err == nil {
+		t.Error("expected an error when no package line can be parsed")
+	}
+}
+
+func TestParseHistoryInfo_Yum(t *testing.T) {
+	output := `Transaction ID : 2
+Begin time     : Tue Sep 15 12:26:40 2020
+Begin rpmdb    : 101:bbb
+End time       : Tue Sep 15 12:27:30 2020 (50 seconds)
+End rpmdb      : 103:ccc
+User           : root <root>
+Return-Code    : Success
+Command Line   : install httpd
+Transaction performed with:
+    Installed     rpm-4.11.3-45.el7.x86_64 @base
+    Installed     yum-3.4.3-168.el7.centos.noarch @base
+Packages Altered:
+    Install     httpd-2.4.6-99.el7.x86_64 @base
+    Dep-Install apr-1.4.8-7.el7.x86_64    @base
+    Updated     bash-4.2.46-34.el7.x86_64 @anaconda
+    Update      bash-4.2.46-35.el7_9.x86_64 @updates
+Scriptlet output:
+   1 Created symlink from /etc/systemd/system/multi-user.target.wants/httpd.service.
+`
+
+	transaction, err := parseHistoryInfo(output)
+	if err != nil {
+		t.Fatalf("parseHistoryInfo() error = %v", err)
+	}
+
+	want := []struct{ action, reason string }{
+		{"Install", ""},
+		{"Install", "dependency"},
+		{"Upgraded", ""},
+		{"Upgrade", ""},
+	}
+	if len(transaction.PackagesAltered) != len(want) {
+		t.Fatalf("PackagesAltered has %d items, want %d", len(transaction.PackagesAltered), len(want))
+	}
+	for i, w := range want {
+		pkg := transaction.PackagesAltered[i]
+		if pkg.Action != w.action || pkg.Reason != w.reason {
+			t.Errorf("package %d: Action = %q, Reason = %q, want %q, %q", i, pkg.Action, pkg.Reason, w.action, w.reason)
+		}
+	}
+
+	wantOutput := []string{"Created symlink from /etc/systemd/system/multi-user.target.wants/httpd.service."}
+	if !reflect.DeepEqual(transaction.ScriptletOutput, wantOutput) {
+		t.Errorf("ScriptletOutput = %q, want %q", transaction.ScriptletOutput, wantOutput)
+	}
+}
+
+func TestParseHistoryInfo_Errors(t *testing.T) {
+	output := `Transaction ID : 7
+Begin time     : Tue 05 Mar 2024 10:12:01 AM UTC
+User           : root <root>
+Return-Code    : Failure: 1
+Packages Altered:
+    Install  httpd-2.4.57-5.el9.x86_64 @appstream
+Scriptlet output:
+   1 useradd: group apache exists
+Errors:
+   1 error: %prein(httpd-2.4.57-5.el9.x86_64) scriptlet failed, exit status 9
+   2 Error in PREIN scriptlet in rpm package httpd
+`
+
+	transaction, err := parseHistoryInfo(output)
+	if err != nil {
+		t.Fatalf("parseHistoryInfo() error = %v", err)
+	}
+
+	wantErrors := []string{
+		"error: %prein(httpd-2.4.57-5.el9.x86_64) scriptlet failed, exit status 9",
+		"Error in PREIN scriptlet in rpm package httpd",
+	}
+	if !reflect.DeepEqual(transaction.Errors, wantErrors) {
+		t.Errorf("Errors = %q, want %q", transaction.Errors, wantErrors)
+	}
+	if transaction.Status != "failure" || transaction.ExitCode != 1 {
+		t.Errorf("status = %q, %d, want %q, 1", transaction.Status, transaction.ExitCode, "failure")
+	}
+	if transaction.ErrorMessage != strings.Join(wantErrors, "\n") {
+		t.Errorf("ErrorMessage = %q, want the errors section", transaction.ErrorMessage)
+	}
+	if len(transaction.ScriptletOutput) != 1 {
+		t.Errorf("ScriptletOutput has %d lines, want 1", len(transaction.ScriptletOutput))
+	}
+}
+
+func TestParseHistoryInfo_Running(t *testing.T) {
+	output := `Transaction ID : 4
+Begin time     : Tue 05 Mar 2024 10:12:01 AM UTC
+Begin rpmdb    : 432:9f2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d
+User           : root <root>
+Releasever     : 9
+Command Line   : install git
+Packages Altered:
+    Install git-2.39.3-1.el9.x86_64 @appstream
+`
+
+	transaction, err := parseHistoryInfo(output)
+	if err != nil {
+		t.Fatalf("parseHistoryInfo() error = %v, want a transaction without Return-Code to be accepted", err)
+	}
+	if transaction.Status != "incomplete" || transaction.ExitCode != -1 {
+		t.Errorf("status = %q, %d, want %q, -1", transaction.Status, transaction.ExitCode, "incomplete")
+	}
+}
+
+func TestParseHistoryInfoStream(t *testing.T) {
+	data, err := os.ReadFile("testdata/dnf4/3.txt")
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	// dnf separates the transactions with a line of dashes
+	separator := strings.Repeat("-", 79) + "\n"
+	second := strings.Replace(string(data), "Transaction ID : 3", "Transaction ID : 4", 1)
+	broken := "Transaction ID : 5\nUser           : root <root>\n"
+	output := string(data) + separator + broken + separator + second
+
+	var transactions []TransactionDetail
+	err = parseHistoryInfoStream(strings.NewReader(output), func(transaction TransactionDetail) {
+		transactions = append(transactions, transaction)
+	})
+
+	var ids []string
+	for _, transaction := range transactions {
+		ids = append(ids, transaction.TransactionID)
+	}
+	if want := []string{"3", "4"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("parsed transactions %v, want %v", ids, want)
+	}
+
+	// A transaction read with others is the same as read alone
+	alone, parseErr := parseHistoryInfo(string(data))
+	if parseErr != nil {
+		t.Fatalf("parseHistoryInfo() error = %v", parseErr)
+	}
+	if len(transactions) > 0 && (!reflect.DeepEqual(transactions[0].ScriptletOutput, alone.ScriptletOutput) || transactions[0].ErrorMessage != alone.ErrorMessage) {
+		t.Errorf("scriptlet output %q and error %q, want %q and %q as read alone", transactions[0].ScriptletOutput, transactions[0].ErrorMessage, alone.ScriptletOutput, alone.ErrorMessage)
+	}
+	if err == nil {
+		t.Error("expected an error for the transaction that cannot be parsed")
+	}
+}
+
+func TestParseHistoryList(t *testing.T) {
+	output := `ID     | Command line             | Date and time    | Action(s)      | Altered
+-------------------------------------------------------------------------------
+     2 | install git              | 2024-03-05 10:15 | Install        |   12
+     1 |                          | 2024-03-01 09:00 | Install        |  420
+`
+
+	entries, err := parseHistoryList(output)
+	if err != nil {
+		t.Fatalf("parseHistoryList() error = %v", err)
+	}
+
+	want := []HistoryEntry{
+		{TransactionID: "1", Actions: "Install", Altered: "420"},
+		{TransactionID: "2", Actions: "Install", Altered: "12"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("parseHistoryList() returned %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	if _, err := parseHistoryList(output + "     3 | broken row\n"); err == nil {
+		t.Error("expected an error for a row that cannot be parsed")
+	}
+}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/txlog/agent/util"
+)
+
+// dnf5Transaction represents a transaction as emitted by
+// 'dnf5 history list --json' and 'dnf5 history info --json'.
+type dnf5Transaction struct {
+	ID                int64         `json:"id"`
+	StartTime         int64         `json:"start_time"`
+	EndTime           int64         `json:"end_time"`
+	RPMDBVersionBegin string        `json:"rpmdb_version_begin"`
+	RPMDBVersionEnd   string        `json:"rpmdb_version_end"`
+	UserID            int64         `json:"user_id"`
+	Status            string        `json:"status"`
+	Releasever        string        `json:"releasever"`
+	Description       string        `json:"description"`
+	CommandLine       string        `json:"command_line"`
+	Comment           string        `json:"comment"`
+	AlteredCount      *int          `json:"altered_count"`
+	Packages          []dnf5Package `json:"packages"`
+}
+
+// dnf5Package represents a package of a dnf5 transaction.
+type dnf5Package struct {
+	NEVRA      string `json:"nevra"`
+	Action     string `json:"action"`
+	Reason     string `json:"reason"`
+	Repository string `json:"repository"`
+}
+
+// dnf5Actions maps the action names used by dnf5 to the ones used by DNF 4,
+// so that all hosts report the same vocabulary. "Replaced" is resolved by
+// resolveDNF5Replaced, as dnf5 uses it for every kind of replaced package.
+var dnf5Actions = map[string]string{
+	"Remove": "Removed",
+}
+
+// dnf5ReplacedActions maps the action of a new package to the action of the
+// package it replaced.
+var dnf5ReplacedActions = map[string]string{
+	"Upgrade":   "Upgraded",
+	"Downgrade": "Downgraded",
+	"Reinstall": "Reinstalled",
+}
+
+// dnf5Reasons maps the install reasons reported by dnf5 to the reasons sent
+// to the server.
+var dnf5Reasons = map[string]string{
+	"User":            reasonUser,
+	"External User":   reasonUser,
+	"Dependency":      reasonDependency,
+	"Weak Dependency": reasonWeakDependency,
+	"Clean":           reasonClean,
+	"Group":           reasonGroup,
+}
+
+// dnf5HistorySource reads the transaction history by parsing the JSON output
+// of the 'dnf5 history' command line.
+type dnf5HistorySource struct{}
+
+// Entries implements HistorySource. The "Action(s)" column is not part of the
+// output of 'dnf5 history list --json' and is left empty.
+func (s *dnf5HistorySource) Entries() ([]HistoryEntry, error) {
+	cmd := exec.Command("dnf5", "history", "list", "--json")
+	util.SetCLocale(cmd)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDNF5HistoryList(out)
+}
+
+// Transaction implements HistorySource.
+func (s *dnf5HistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	if !reValidInput.MatchString(transactionID) {
+		return TransactionDetail{}, fmt.Errorf("invalid input")
+	}
+
+	cmd := exec.Command("dnf5", "history", "info", transactionID, "--json")
+	util.SetCLocale(cmd)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+
+	transactions, err := parseDNF5HistoryInfo(out)
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+
+	for _, transaction := range transactions {
+		if transaction.TransactionID == transactionID {
+			return transaction, nil
+		}
+	}
+
+	return TransactionDetail{}, fmt.Errorf("transaction %s not found in dnf5 history", transactionID)
+}
+
+// Transactions implements batchHistorySource, as 'dnf5 history info' accepts
+// many transactions and returns all of them in a single JSON array.
+func (s *dnf5HistorySource) Transactions(transactionIDs []string) (map[string]TransactionDetail, error) {
+	for _, transactionID := range transactionIDs {
+		if !reValidInput.MatchString(transactionID) {
+			return nil, fmt.Errorf("invalid input")
+		}
+	}
+
+	args := append([]string{"history", "info"}, transactionIDs...)
+	cmd := exec.Command("dnf5", append(args, "--json")...)
+	util.SetCLocale(cmd)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := parseDNF5HistoryInfo(out)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]TransactionDetail, len(transactions))
+	for _, transaction := range transactions {
+		result[transaction.TransactionID] = transaction
+	}
+
+	return result, nil
+}
+
+// Close implements HistorySource.
+func (s *dnf5HistorySource) Close() error {
+	return nil
+}
+
+// parseDNF5HistoryList parses the output of 'dnf5 history list --json'.
+func parseDNF5HistoryList(data []byte) ([]HistoryEntry, error) {
+	var transactions []dnf5Transaction
+	if err := json.Unmarshal(data, &transactions); err != nil {
+		return nil, fmt.Errorf("failed to parse dnf5 history list: %w", err)
+	}
+
+	sort.Slice(transactions, func(i, j int) bool {
+		return transactions[i].ID < transactions[j].ID
+	})
+
+	entries := make([]HistoryEntry, 0, len(transactions))
+	for _, t := range transactions {
+		entry := HistoryEntry{TransactionID: strconv.FormatInt(t.ID, 10)}
+		if t.AlteredCount != nil {
+			entry.Altered = strconv.Itoa(*t.AlteredCount)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// parseDNF5HistoryInfo parses the output of 'dnf5 history info --json' into
+// the same TransactionDetail produced for DNF 4 hosts.
+func parseDNF5HistoryInfo(data []byte) ([]TransactionDetail, error) {
+	var transactions []dnf5Transaction
+	if err := json.Unmarshal(data, &transactions); err != nil {
+		return nil, fmt.Errorf("failed to parse dnf5 history info: %w", err)
+	}
+
+	details := make([]TransactionDetail, 0, len(transactions))
+	for _, t := range transactions {
+		detail := TransactionDetail{
+			TransactionID: strconv.FormatInt(t.ID, 10),
+			BeginRPMDB:    t.RPMDBVersionBegin,
+			EndRPMDB:      t.RPMDBVersionEnd,
+			User:          util.UserDisplayName(t.UserID),
+			Releasever:    t.Releasever,
+			CommandLine:   t.CommandLine,
+			Comment:       t.Comment,
+		}
+
+		// dnf5 stores the command line as the transaction description
+		if detail.CommandLine == "" {
+			detail.CommandLine = t.Description
+		}
+		if t.StartTime > 0 {
+			detail.BeginTime = util.FormatTimestamp(t.StartTime)
+		}
+		if t.EndTime > 0 {
+			detail.EndTime = util.FormatTimestamp(t.EndTime)
+		}
+
+		switch t.Status {
+		case "Ok":
+			detail.ReturnCode = "Success"
+		case "Error":
+			detail.ReturnCode = "Failure: 1"
+		default:
+			detail.ReturnCode = "** Aborted **"
+		}
+
+		detail.PackagesAltered = resolveDNF5Replaced(t.Packages)
+		completeTransactionDetail(&detail)
+		details = append(details, detail)
+	}
+
+	return details, nil
+}
+
+// resolveDNF5Replaced converts dnf5 packages to Package values. Packages
+// marked as "Replaced" get the DNF 4 action matching the package that
+// replaced them (Upgraded, Downgraded, Reinstalled), or "Obsoleted" when
+// they were replaced by a package with a different name. Versions and
+// repositories follow readDNFHistoryItems: a zero epoch is dropped and a
+// repoid starting with "@", such as "@System", is in FromRepo without it.
+func resolveDNF5Replaced(packages []dnf5Package) []Package {
+	newActions := make(map[string]string)
+	for _, p := range packages {
+		if p.Action != "Replaced" {
+			name, _, _, _, arch := util.SplitPackageName(p.NEVRA)
+			newActions[name+"."+arch] = p.Action
+		}
+	}
+
+	result := make([]Package, 0, len(packages))
+	for _, p := range packages {
+		name, version, release, epoch, arch := util.SplitPackageName(p.NEVRA)
+		if epoch == "0" {
+			epoch = ""
+			version = strings.TrimPrefix(version, "0:")
+		}
+
+		action := p.Action
+		if mapped, ok := dnf5Actions[action]; ok {
+			action = mapped
+		}
+		if action == "Replaced" {
+			if replaced, ok := dnf5ReplacedActions[newActions[name+"."+arch]]; ok {
+				action = replaced
+			} else {
+				action = "Obsoleted"
+			}
+		}
+
+		pkg := Package{
+			Action:  action,
+			Name:    name,
+			Version: version,
+			Release: release,
+			Epoch:   epoch,
+			Arch:    arch,
+			Reason:  dnf5Reasons[p.Reason],
+		}
+		if fromRepo, found := strings.CutPrefix(p.Repository, "@"); found {
+			pkg.FromRepo = fromRepo
+		} else {
+			pkg.Repo = p.Repository
+		}
+		result = append(result, pkg)
+	}
+
+	return result
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"testing"
+)
+
+const dnf5HistoryInfoJSON = `[
+  {
+    "id": 12,
+    "start_time": 1730000000,
+    "end_time": 1730000042,
+    "rpmdb_version_begin": "1522:aa",
+    "rpmdb_version_end": "1523:bb",
+    "user_id": 4294967295,
+    "status": "Ok",
+    "releasever": "41",
+    "description": "dnf5 upgrade openssl",
+    "comment": "",
+    "packages": [
+      {"nevra": "openssl-1:3.2.2-9.fc41.x86_64", "action": "Upgrade", "reason": "User", "repository": "updates"},
+      {"nevra": "openssl-1:3.2.2-5.fc41.x86_64", "action": "Replaced", "reason": "User", "repository": "@System"},
+      {"nevra": "compat-lib-1.0-1.fc41.x86_64", "action": "Replaced", "reason": "Dependency", "repository": "@System"},
+      {"nevra": "old-tool-0:2.0-3.fc40.noarch", "action": "Remove", "reason": "Clean", "repository": "@System"}
+    ]
+  }
+]`
+
+func TestParseDNF5HistoryInfo(t *testing.T) {
+	transactions, err := parseDNF5HistoryInfo([]byte(dnf5HistoryInfoJSON))
+	if err != nil {
+		t.Fatalf("parseDNF5HistoryInfo() error = %v", err)
+	}
+	if len(transactions) != 1 {
+		t.Fatalf("parseDNF5HistoryInfo() returned %d transactions, want 1", len(transactions))
+	}
+
+	transaction := transactions[0]
+	if transaction.TransactionID != "12" {
+		t.Errorf("TransactionID = %q, want %q", transaction.TransactionID, "12")
+	}
+	if transaction.CommandLine != "dnf5 upgrade openssl" {
+		t.Errorf("CommandLine = %q, want %q", transaction.CommandLine, "dnf5 upgrade openssl")
+	}
+	if transaction.ReturnCode != "Success" {
+		t.Errorf("ReturnCode = %q, want %q", transaction.ReturnCode, "Success")
+	}
+	if transaction.User != "System <unset>" {
+		t.Errorf("User = %q, want %q", transaction.User, "System <unset>")
+	}
+	if transaction.Releasever != "41" {
+		t.Errorf("Releasever = %q, want %q", transaction.Releasever, "41")
+	}
+
+	want := []Package{
+		{Action: "Upgrade", Name: "openssl", Version: "1:3.2.2", Release: "9.fc41", Epoch: "1", Arch: "x86_64", Repo: "updates", Reason: "user"},
+		{Action: "Upgraded", Name: "openssl", Version: "1:3.2.2", Release: "5.fc41", Epoch: "1", Arch: "x86_64", FromRepo: "System", Reason: "user"},
+		{Action: "Obsoleted", Name: "compat-lib", Version: "1.0", Release: "1.fc41", Arch: "x86_64", FromRepo: "System", Reason: "dependency"},
+		{Action: "Removed", Name: "old-tool", Version: "2.0", Release: "3.fc40", Arch: "noarch", FromRepo: "System", Reason: "clean"},
+	}
+	if len(transaction.PackagesAltered) != len(want) {
+		t.Fatalf("PackagesAltered has %d items, want %d", len(transaction.PackagesAltered), len(want))
+	}
+	for i := range want {
+		if transaction.PackagesAltered[i] != want[i] {
+			t.Errorf("package %d = %+v, want %+v", i, transaction.PackagesAltered[i], want[i])
+		}
+	}
+
+	actions, altered := summarizePackageActions(transaction.PackagesAltered)
+	if actions != "E, O, U" || altered != "3" {
+		t.Errorf("summarizePackageActions() = (%q, %q), want (%q, %q)", actions, altered, "E, O, U", "3")
+	}
+}
+
+func TestParseDNF5HistoryList(t *testing.T) {
+	entries, err := parseDNF5HistoryList([]byte(`[
+		{"id": 3, "description": "dnf5 install git", "altered_count": 4},
+		{"id": 1, "description": "dnf5 install vim"}
+	]`))
+	if err != nil {
+		t.Fatalf("parseDNF5HistoryList() error = %v", err)
+	}
+
+	want := []HistoryEntry{
+		{TransactionID: "1"},
+		{TransactionID: "3", Altered: "4"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("parseDNF5HistoryList() returned %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	if _, err := parseDNF5HistoryList([]byte("ID Command line")); err == nil {
+		t.Error("expected an error for non-JSON output")
+	}
+}
@@ -0,0 +1,418 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/txlog/agent/util"
+	_ "modernc.org/sqlite"
+)
+
+// dnfHistoryDBPath is the location of the DNF software database (swdb).
+var dnfHistoryDBPath = "/var/lib/dnf/history.sqlite"
+
+// dnfItemAction holds the long and short names DNF uses to display a
+// trans_item.action value.
+type dnfItemAction struct {
+	name  string
+	short string
+}
+
+// dnfItemActions maps libdnf's TransactionItemAction enum to the names shown
+// by 'dnf history info' and 'dnf history list'.
+var dnfItemActions = map[int]dnfItemAction{
+	1:  {"Install", "I"},
+	2:  {"Downgrade", "D"},
+	3:  {"Downgraded", "D"},
+	4:  {"Obsolete", "O"},
+	5:  {"Obsoleted", "O"},
+	6:  {"Upgrade", "U"},
+	7:  {"Upgraded", "U"},
+	8:  {"Removed", "E"},
+	9:  {"Reinstall", "R"},
+	10: {"Reinstalled", "R"},
+	11: {"Reason Change", "C"},
+}
+
+// dnfItemReasons maps libdnf's TransactionItemReason enum, stored in
+// trans_item.reason, to the reasons sent to the server. 0 means unknown.
+var dnfItemReasons = map[int]string{
+	1: reasonDependency,
+	2: reasonUser,
+	3: reasonClean,
+	4: reasonWeakDependency,
+	5: reasonGroup,
+}
+
+// libdnf enum values needed to reproduce the CLI output.
+const (
+	dnfActionDowngraded = 3
+	dnfActionUpgraded   = 7
+	dnfStateUnknown     = 0
+	dnfStateDone        = 1
+	dnfStateError       = 2
+	dnfStderr           = 2
+)
+
+// dnfDBHistorySource reads the transaction history from a DNF history database.
+type dnfDBHistorySource struct {
+	db *sql.DB
+}
+
+// newDNFDBHistorySource opens the DNF history database at path in read-only
+// mode. It returns an error if the database does not exist or cannot be read.
+func newDNFDBHistorySource(path string) (*dnfDBHistorySource, error) {
+	db, err := openSQLiteReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dnfDBHistorySource{db: db}, nil
+}
+
+// Entries implements HistorySource.
+func (s *dnfDBHistorySource) Entries() ([]HistoryEntry, error) {
+	return readDNFHistoryEntries(s.db)
+}
+
+// Transaction implements HistorySource.
+func (s *dnfDBHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	return readDNFHistoryTransaction(s.db, transactionID)
+}
+
+// LatestTransactionID implements latestHistorySource.
+func (s *dnfDBHistorySource) LatestTransactionID() (string, error) {
+	var id sql.NullInt64
+	if err := s.db.QueryRow(`SELECT max(id) FROM trans`).Scan(&id); err != nil {
+		return "", err
+	}
+	if !id.Valid {
+		return "", nil
+	}
+	return strconv.FormatInt(id.Int64, 10), nil
+}
+
+// Close implements HistorySource.
+func (s *dnfDBHistorySource) Close() error {
+	return s.db.Close()
+}
+
+// readDNFHistoryEntries lists all transactions in the DNF history database in
+// ascending order, computing the "Action(s)" and "Altered" columns the same
+// way 'dnf history list' does.
+func readDNFHistoryEntries(db *sql.DB) ([]HistoryEntry, error) {
+	rows, err := db.Query(`
+		SELECT t.id, i.action
+		FROM trans t
+		LEFT JOIN (
+			SELECT ti.id, ti.trans_id, ti.action
+			FROM trans_item ti
+			JOIN rpm r ON r.item_id = ti.item_id
+		) i ON i.trans_id = t.id
+		ORDER BY t.id, i.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]HistoryEntry, 0)
+	var ids []int64
+	actions := make(map[int64]map[string]string)
+	altered := make(map[int64]int)
+
+	for rows.Next() {
+		var id int64
+		var action sql.NullInt64
+		if err := rows.Scan(&id, &action); err != nil {
+			return nil, err
+		}
+
+		if _, seen := actions[id]; !seen {
+			ids = append(ids, id)
+			actions[id] = make(map[string]string)
+		}
+
+		// 'dnf history list' hides the replaced side of upgrades and downgrades
+		if !action.Valid || action.Int64 == dnfActionUpgraded || action.Int64 == dnfActionDowngraded {
+			continue
+		}
+
+		a, ok := dnfItemActions[int(action.Int64)]
+		if !ok {
+			a = dnfItemAction{name: strconv.FormatInt(action.Int64, 10), short: "?"}
+		}
+		actions[id][a.name] = a.short
+		altered[id]++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		entries = append(entries, HistoryEntry{
+			TransactionID: strconv.FormatInt(id, 10),
+			Actions:       summarizeActions(actions[id]),
+			Altered:       strconv.Itoa(altered[id]),
+		})
+	}
+
+	return entries, nil
+}
+
+// summarizeActions renders the set of actions of a transaction as DNF does:
+// the long name when there is a single action, or a sorted list of short
+// names otherwise.
+func summarizeActions(actions map[string]string) string {
+	if len(actions) == 1 {
+		for name := range actions {
+			return name
+		}
+	}
+
+	short := make(map[string]struct{}, len(actions))
+	for _, s := range actions {
+		short[s] = struct{}{}
+	}
+
+	names := make([]string, 0, len(short))
+	for s := range short {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// summarizePackageActions computes the "Action(s)" and "Altered" columns of
+// 'dnf history list' from the packages of a transaction, for sources that do
+// not provide them.
+func summarizePackageActions(packages []Package) (string, string) {
+	short := make(map[string]string, len(dnfItemActions))
+	for _, a := range dnfItemActions {
+		short[a.name] = a.short
+	}
+
+	actions := make(map[string]string)
+	altered := 0
+	for _, pkg := range packages {
+		if pkg.Action == "Upgraded" || pkg.Action == "Downgraded" {
+			continue
+		}
+		s, ok := short[pkg.Action]
+		if !ok {
+			s = "?"
+		}
+		actions[pkg.Action] = s
+		altered++
+	}
+
+	return summarizeActions(actions), strconv.Itoa(altered)
+}
+
+// readDNFHistoryTransaction reads a single transaction from the DNF history
+// database and maps it to the same TransactionDetail that is produced by
+// parsing 'dnf history info'.
+func readDNFHistoryTransaction(db *sql.DB, transactionID string) (TransactionDetail, error) {
+	if !reValidInput.MatchString(transactionID) {
+		return TransactionDetail{}, fmt.Errorf("invalid input")
+	}
+
+	// Columns are read by name so that schema additions between DNF
+	// releases do not break the reader
+	trans, err := queryRowMap(db, "SELECT * FROM trans WHERE id = ?", transactionID)
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+	if trans == nil {
+		return TransactionDetail{}, fmt.Errorf("transaction %s not found in DNF history", transactionID)
+	}
+
+	transaction := TransactionDetail{
+		TransactionID: transactionID,
+		BeginRPMDB:    columnString(trans, "rpmdb_version_begin"),
+		EndRPMDB:      columnString(trans, "rpmdb_version_end"),
+		Releasever:    columnString(trans, "releasever"),
+		CommandLine:   columnString(trans, "cmdline"),
+		Comment:       columnString(trans, "comment"),
+	}
+
+	if begin := columnInt(trans, "dt_begin"); begin > 0 {
+		transaction.BeginTime = util.FormatTimestamp(begin)
+	}
+	if end := columnInt(trans, "dt_end"); end > 0 {
+		transaction.EndTime = util.FormatTimestamp(end)
+	}
+	if _, ok := trans["user_id"]; ok {
+		transaction.User = util.UserDisplayName(columnInt(trans, "user_id"))
+	}
+	transaction.ReturnCode = dnfReturnCode(columnInt(trans, "state"))
+
+	transaction.PackagesAltered, err = readDNFHistoryItems(db, transactionID)
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+
+	transaction.ScriptletOutput, transaction.Errors, err = readDNFHistoryOutput(db, transactionID)
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+	completeTransactionDetail(&transaction)
+
+	return transaction, nil
+}
+
+// dnfReturnCode returns the Return-Code of a transaction in the given swdb
+// state. Like the dnf CLI, whose return code is 1 for any state but done,
+// a transaction still running, or interrupted before dnf recorded its end,
+// is a failure as well as one in the error state.
+func dnfReturnCode(state int64) string {
+	if state == dnfStateDone {
+		return "Success"
+	}
+	return "Failure: 1"
+}
+
+// readDNFHistoryItems returns the RPM packages altered by a transaction, with
+// the same values parsed from 'dnf history info': the version is prefixed
+// with the epoch, when there is one, and a repoid starting with "@", such as
+// "@System" for the replaced side of an upgrade, is in FromRepo without it,
+// as dnf prints it "@@System".
+func readDNFHistoryItems(db *sql.DB, transactionID string) ([]Package, error) {
+	rows, err := db.Query(`
+		SELECT ti.action, ti.reason, r.name, COALESCE(r.epoch, 0), r.version, r.release, r.arch, COALESCE(repo.repoid, '')
+		FROM trans_item ti
+		JOIN rpm r ON r.item_id = ti.item_id
+		LEFT JOIN repo ON repo.id = ti.repo_id
+		WHERE ti.trans_id = ?
+		ORDER BY ti.id`, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var packages []Package
+	for rows.Next() {
+		var action, reason, epoch int64
+		var repo string
+		var pkg Package
+		if err := rows.Scan(&action, &reason, &pkg.Name, &epoch, &pkg.Version, &pkg.Release, &pkg.Arch, &repo); err != nil {
+			return nil, err
+		}
+
+		if a, ok := dnfItemActions[int(action)]; ok {
+			pkg.Action = a.name
+		} else {
+			pkg.Action = strconv.FormatInt(action, 10)
+		}
+		if epoch > 0 {
+			pkg.Epoch = strconv.FormatInt(epoch, 10)
+			pkg.Version = pkg.Epoch + ":" + pkg.Version
+		}
+		if fromRepo, found := strings.CutPrefix(repo, "@"); found {
+			pkg.FromRepo = fromRepo
+		} else {
+			pkg.Repo = repo
+		}
+		pkg.Reason = dnfItemReasons[int(reason)]
+
+		packages = append(packages, pkg)
+	}
+
+	return packages, rows.Err()
+}
+
+// readDNFHistoryOutput returns the console output recorded for a transaction.
+// As in 'dnf history info', lines written to stdout are the scriptlet output
+// and lines written to stderr are the errors.
+func readDNFHistoryOutput(db *sql.DB, transactionID string) ([]string, []string, error) {
+	rows, err := db.Query("SELECT file_descriptor, line FROM console_output WHERE trans_id = ? ORDER BY id", transactionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var output, errors []string
+	for rows.Next() {
+		var fd int64
+		var line string
+		if err := rows.Scan(&fd, &line); err != nil {
+			return nil, nil, err
+		}
+		if fd == dnfStderr {
+			errors = append(errors, strings.TrimRight(line, "\n"))
+		} else {
+			output = append(output, strings.TrimRight(line, "\n"))
+		}
+	}
+
+	return output, errors, rows.Err()
+}
+
+// queryRowMap runs a query expected to return at most one row and returns it
+// as a map of column name to value. It returns a nil map if no row is found.
+func queryRowMap(db *sql.DB, query string, args ...interface{}) (map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err := rows.Scan(pointers...); err != nil {
+		return nil, err
+	}
+
+	row := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		row[column] = values[i]
+	}
+
+	return row, nil
+}
+
+// columnString returns a column of a row map as a string, or an empty string
+// if the column is absent or NULL.
+func columnString(row map[string]interface{}, column string) string {
+	switch v := row[column].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return ""
+}
+
+// columnInt returns a column of a row map as an integer, or zero if the
+// column is absent, NULL or not numeric.
+func columnInt(row map[string]interface{}, column string) int64 {
+	switch v := row[column].(type) {
+	case int64:
+		return v
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	case []byte:
+		n, _ := strconv.ParseInt(string(v), 10, 64)
+		return n
+	}
+	return 0
+}
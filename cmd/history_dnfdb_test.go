@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/txlog/agent/util"
+)
+
+// dnfHistorySchema mirrors the tables created by libdnf's swdb.
+const dnfHistorySchema = `
+CREATE TABLE trans (id INTEGER PRIMARY KEY, dt_begin INTEGER NOT NULL, dt_end INTEGER, rpmdb_version_begin TEXT, rpmdb_version_end TEXT, releasever TEXT NOT NULL, user_id INTEGER NOT NULL, cmdline TEXT, state INTEGER NOT NULL, comment TEXT);
+CREATE TABLE repo (id INTEGER PRIMARY KEY, repoid TEXT NOT NULL);
+CREATE TABLE item (id INTEGER PRIMARY KEY, item_type INTEGER NOT NULL);
+CREATE TABLE trans_item (id INTEGER PRIMARY KEY, trans_id INTEGER, item_id INTEGER, repo_id INTEGER, action INTEGER NOT NULL, reason INTEGER NOT NULL, state INTEGER NOT NULL);
+CREATE TABLE console_output (id INTEGER PRIMARY KEY, trans_id INTEGER, file_descriptor INTEGER NOT NULL, line TEXT NOT NULL);
+CREATE TABLE rpm (item_id INTEGER UNIQUE NOT NULL, name TEXT NOT NULL, epoch INTEGER NOT NULL, version TEXT NOT NULL, release TEXT NOT NULL, arch TEXT NOT NULL);
+CREATE TABLE comps_group (item_id INTEGER UNIQUE NOT NULL, groupid TEXT NOT NULL, name TEXT NOT NULL, translated_name TEXT NOT NULL, pkg_types INTEGER NOT NULL);
+
+INSERT INTO repo VALUES (1, 'baseos'), (2, 'appstream'), (3, '@System');
+INSERT INTO item VALUES (1, 1), (2, 1), (3, 1), (4, 1), (5, 2);
+INSERT INTO rpm VALUES
+	(1, 'vim-enhanced', 2, '8.2.2637', '20.el9', 'x86_64'),
+	(2, 'openssl', 1, '3.0.7', '24.el9', 'x86_64'),
+	(3, 'openssl', 1, '3.0.7', '27.el9', 'x86_64'),
+	(4, 'git', 0, '2.39.3', '1.el9', 'x86_64');
+INSERT INTO comps_group VALUES (5, 'core', 'Core', 'Core', 1);
+
+INSERT INTO trans VALUES
+	(1, 1700000000, 1700000010, 'a1', 'b1', '9', 0, 'install vim-enhanced', 1, ''),
+	(2, 1700100000, 1700100020, 'b1', 'c1', '9', 0, 'upgrade openssl', 1, 'security'),
+	(3, 1700200000, 0, 'c1', NULL, '9', 4294967295, 'install git @core', 0, NULL);
+INSERT INTO trans_item VALUES
+	(1, 1, 1, 2, 1, 2, 1),
+	(2, 2, 3, 1, 6, 2, 1),
+	(3, 2, 2, 3, 7, 2, 1),
+	(4, 3, 4, 2, 1, 1, 1),
+	(5, 3, 5, 1, 1, 2, 1);
+INSERT INTO console_output VALUES
+	(1, 2, 1, 'Running scriptlet: openssl'),
+	(2, 2, 2, 'warning: /etc/pki/tls/openssl.cnf created as /etc/pki/tls/openssl.cnf.rpmnew'),
+	(3, 3, 2, 'Error: Transaction test error');
+`
+
+// newTestDNFHistoryDB creates a DNF history database populated with a few
+// transactions and returns a source reading it.
+func newTestDNFHistoryDB(t *testing.T) *dnfDBHistorySource {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "history.sqlite")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(dnfHistorySchema); err != nil {
+		t.Fatalf("failed to populate database: %v", err)
+	}
+
+	source, err := newDNFDBHistorySource(path)
+	if err != nil {
+		t.Fatalf("newDNFDBHistorySource() error = %v", err)
+	}
+	t.Cleanup(func() { source.Close() })
+
+	return source
+}
+
+func TestReadDNFHistoryEntries(t *testing.T) {
+	source := newTestDNFHistoryDB(t)
+
+	entries, err := source.Entries()
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+
+	want := []HistoryEntry{
+		{TransactionID: "1", Actions: "Install", Altered: "1"},
+		{TransactionID: "2", Actions: "Upgrade", Altered: "1"},
+		{TransactionID: "3", Actions: "Install", Altered: "1"},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("Entries() returned %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestReadDNFHistoryTransaction(t *testing.T) {
+	source := newTestDNFHistoryDB(t)
+
+	transaction, err := source.Transaction("2")
+	if err != nil {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+
+	if transaction.TransactionID != "2" {
+		t.Errorf("TransactionID = %q, want %q", transaction.TransactionID, "2")
+	}
+	if transaction.ReturnCode != "Success" {
+		t.Errorf("ReturnCode = %q, want %q", transaction.ReturnCode, "Success")
+	}
+	if transaction.CommandLine != "upgrade openssl" {
+		t.Errorf("CommandLine = %q, want %q", transaction.CommandLine, "upgrade openssl")
+	}
+	if transaction.Comment != "security" {
+		t.Errorf("Comment = %q, want %q", transaction.Comment, "security")
+	}
+	if transaction.BeginRPMDB != "b1" || transaction.EndRPMDB != "c1" {
+		t.Errorf("rpmdb = %q..%q, want %q..%q", transaction.BeginRPMDB, transaction.EndRPMDB, "b1", "c1")
+	}
+	if transaction.BeginTime == "" || transaction.EndTime == "" {
+		t.Errorf("expected begin and end time to be set, got %q and %q", transaction.BeginTime, transaction.EndTime)
+	}
+
+	wantPackages := []Package{
+		{Action: "Upgrade", Name: "openssl", Version: "1:3.0.7", Release: "27.el9", Epoch: "1", Arch: "x86_64", Repo: "baseos", Reason: "user"},
+		{Action: "Upgraded", Name: "openssl", Version: "1:3.0.7", Release: "24.el9", Epoch: "1", Arch: "x86_64", FromRepo: "System", Reason: "user"},
+	}
+	if len(transaction.PackagesAltered) != len(wantPackages) {
+		t.Fatalf("PackagesAltered has %d items, want %d", len(transaction.PackagesAltered), len(wantPackages))
+	}
+	for i := range wantPackages {
+		if transaction.PackagesAltered[i] != wantPackages[i] {
+			t.Errorf("package %d = %+v, want %+v", i, transaction.PackagesAltered[i], wantPackages[i])
+		}
+	}
+
+	if len(transaction.ScriptletOutput) != 1 {
+		t.Errorf("ScriptletOutput has %d lines, want 1", len(transaction.ScriptletOutput))
+	}
+	if len(transaction.Errors) != 1 {
+		t.Errorf("Errors has %d lines, want 1 (stderr lines are errors)", len(transaction.Errors))
+	}
+	if transaction.Status != "success" || transaction.ExitCode != 0 || transaction.ErrorMessage != "" {
+		t.Errorf("status = %q, %d, %q, want %q, 0, empty", transaction.Status, transaction.ExitCode, transaction.ErrorMessage, "success")
+	}
+}
+
+func TestReadDNFHistoryTransaction_Unfinished(t *testing.T) {
+	source := newTestDNFHistoryDB(t)
+
+	transaction, err := source.Transaction("3")
+	if err != nil {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+
+	// As printed by 'dnf history info' for a transaction without an end
+	if transaction.ReturnCode != "Failure: 1" {
+		t.Errorf("ReturnCode = %q, want %q", transaction.ReturnCode, "Failure: 1")
+	}
+	if transaction.Status != "failure" || transaction.ExitCode != 1 {
+		t.Errorf("status = %q, %d, want %q, 1", transaction.Status, transaction.ExitCode, "failure")
+	}
+	if transaction.ErrorMessage != "Error: Transaction test error" {
+		t.Errorf("ErrorMessage = %q, want %q", transaction.ErrorMessage, "Error: Transaction test error")
+	}
+	if transaction.EndTime != "" {
+		t.Errorf("EndTime = %q, want empty", transaction.EndTime)
+	}
+	if transaction.User != "System <unset>" {
+		t.Errorf("User = %q, want %q", transaction.User, "System <unset>")
+	}
+	if len(transaction.PackagesAltered) != 1 {
+		t.Fatalf("PackagesAltered has %d items, want 1 (groups are not packages)", len(transaction.PackagesAltered))
+	}
+	if transaction.PackagesAltered[0].Reason != "dependency" {
+		t.Errorf("Reason = %q, want %q", transaction.PackagesAltered[0].Reason, "dependency")
+	}
+}
+
+func TestReadDNFHistoryTransaction_NotFound(t *testing.T) {
+	source := newTestDNFHistoryDB(t)
+
+	if _, err := source.Transaction("42"); err == nil {
+		t.Error("expected an error for a missing transaction")
+	}
+	if _, err := source.Transaction("1; DROP TABLE trans"); err == nil {
+		t.Error("expected an error for an invalid transaction ID")
+	}
+}
+
+func TestSummarizeActions(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions map[string]string
+		want    string
+	}{
+		{name: "empty", actions: map[string]string{}, want: ""},
+		{name: "single action", actions: map[string]string{"Install": "I"}, want: "Install"},
+		{name: "multiple actions", actions: map[string]string{"Upgrade": "U", "Install": "I", "Removed": "E"}, want: "E, I, U"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeActions(tt.actions); got != tt.want {
+				t.Errorf("summarizeActions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNFDBLatestTransactionID(t *testing.T) {
+	source := newTestDNFHistoryDB(t)
+
+	transactionID, err := latestTransactionID(source)
+	if err != nil {
+		t.Fatalf("latestTransactionID() error = %v", err)
+	}
+	if transactionID != "3" {
+		t.Errorf("latestTransactionID() = %q, want \"3\"", transactionID)
+	}
+}
+
+func TestDNFReturnCode(t *testing.T) {
+	tests := []struct {
+		state int64
+		want  string
+	}{
+		{dnfStateDone, "Success"},
+		{dnfStateError, "Failure: 1"},
+		// dnf prints "Failure: 1" for a transaction that did not finish
+		{dnfStateUnknown, "Failure: 1"},
+	}
+	for _, tt := range tests {
+		if got := dnfReturnCode(tt.state); got != tt.want {
+			t.Errorf("dnfReturnCode(%d) = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestReadDNFHistoryTransaction_MatchesCLI(t *testing.T) {
+	source := newTestDNFHistoryDB(t)
+
+	fromDB, err := source.Transaction("2")
+	if err != nil {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+
+	// The same transaction, as printed by 'dnf history info 2'
+	const layout = "Mon 02 Jan 2006 03:04:05 PM MST"
+	output := fmt.Sprintf(`Transaction ID : 2
+Begin time     : %s
+Begin rpmdb    : b1
+End time       : %s (20 seconds)
+End rpmdb      : c1
+User           : %s
+Return-Code    : Success
+Releasever     : 9
+Command Line   : upgrade openssl
+Comment        : security
+Packages Altered:
+    Upgrade  openssl-1:3.0.7-27.el9.x86_64 @baseos
+    Upgraded openssl-1:3.0.7-24.el9.x86_64 @@System
+Scriptlet output:
+   1 Running scriptlet: openssl
+Errors:
+   1 warning: /etc/pki/tls/openssl.cnf created as /etc/pki/tls/openssl.cnf.rpmnew
+`, time.Unix(1700100000, 0).UTC().Format(layout), time.Unix(1700100020, 0).UTC().Format(layout), util.UserDisplayName(0))
+
+	fromCLI, err := parseHistoryInfo(output)
+	if err != nil {
+		t.Fatalf("parseHistoryInfo() error = %v", err)
+	}
+
+	// Times are printed by dnf in the local time zone, so only the instants
+	// are compared
+	for _, transaction := range []*TransactionDetail{&fromDB, &fromCLI} {
+		transaction.BeginTime = normalizeTime(transaction.BeginTime)
+		transaction.EndTime = normalizeTime(transaction.EndTime)
+	}
+
+	// 'dnf history info' does not print the install reason
+	for i := range fromDB.PackagesAltered {
+		fromDB.PackagesAltered[i].Reason = ""
+	}
+
+	if !reflect.DeepEqual(fromDB, fromCLI) {
+		t.Errorf("history.sqlite and 'dnf history info' differ:\n  sqlite: %+v\n  CLI:    %+v", fromDB, fromCLI)
+	}
+}
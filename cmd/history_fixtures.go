@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fixturesHistorySource reads the transaction history from command outputs
+// recorded on another host, which allows reproducing problems without access
+// to it. The directory holds:
+//   - list.txt: the output of 'dnf history list', or list.json with the output
+//     of 'dnf5 history list --json'
+//   - <id>.txt: the output of 'dnf history info <id>', or <id>.json with the
+//     output of 'dnf5 history info <id> --json'
+//
+// When no list file is present, the transactions are listed from the <id>
+// files found in the directory.
+type fixturesHistorySource struct {
+	dir string
+}
+
+// newFixturesHistorySource returns a source reading the fixtures in dir.
+func newFixturesHistorySource(dir string) (*fixturesHistorySource, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("fixtures history source requires a directory, as in fixtures:/path")
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	return &fixturesHistorySource{dir: dir}, nil
+}
+
+// Entries implements HistorySource.
+func (s *fixturesHistorySource) Entries() ([]HistoryEntry, error) {
+	if data, err := os.ReadFile(filepath.Join(s.dir, "list.json")); err == nil {
+		return parseDNF5HistoryList(data)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(s.dir, "list.txt")); err == nil {
+		return parseHistoryList(string(data))
+	}
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimSuffix(file.Name(), ".txt"), ".json")
+		if id, err := strconv.Atoi(name); err == nil && reValidInput.MatchString(name) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	entries := make([]HistoryEntry, 0, len(ids))
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+		entries = append(entries, HistoryEntry{TransactionID: strconv.Itoa(id)})
+	}
+
+	return entries, nil
+}
+
+// Transaction implements HistorySource.
+func (s *fixturesHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	if !reValidInput.MatchString(transactionID) {
+		return TransactionDetail{}, fmt.Errorf("invalid input")
+	}
+
+	if data, err := os.ReadFile(filepath.Join(s.dir, transactionID+".json")); err == nil {
+		transactions, err := parseDNF5HistoryInfo(data)
+		if err != nil {
+			return TransactionDetail{}, err
+		}
+		for _, transaction := range transactions {
+			if transaction.TransactionID == transactionID {
+				return transaction, nil
+			}
+		}
+		return TransactionDetail{}, fmt.Errorf("transaction %s not found in %s.json", transactionID, transactionID)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, transactionID+".txt"))
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+
+	transaction, err := parseHistoryInfo(string(data))
+	if err != nil {
+		return TransactionDetail{}, fmt.Errorf("%s.txt: %w", transactionID, err)
+	}
+	if transaction.TransactionID != transactionID {
+		return TransactionDetail{}, fmt.Errorf("%s.txt describes transaction %s", transactionID, transaction.TransactionID)
+	}
+
+	return transaction, nil
+}
+
+// Close implements HistorySource.
+func (s *fixturesHistorySource) Close() error {
+	return nil
+}
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestOpenHistorySource(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "cli"},
+		{spec: "dnf5"},
+		{spec: "fixtures:testdata/dnf4"},
+		{spec: "fixtures:", wantErr: true},
+		{spec: "fixtures:testdata/missing", wantErr: true},
+		{spec: "sqlite:testdata/missing.sqlite", wantErr: true},
+		{spec: "yum:testdata/dnf4", wantErr: true},
+		{spec: "rpm", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			source, err := openHistorySource(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openHistorySource(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if source != nil {
+				source.Close()
+			}
+		})
+	}
+}
+
+func TestFixturesHistorySource(t *testing.T) {
+	source, err := openHistorySource("fixtures:testdata/dnf4")
+	if err != nil {
+		t.Fatalf("openHistorySource() error = %v", err)
+	}
+	defer source.Close()
+
+	entries, err := source.Entries()
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+
+	want := []HistoryEntry{
+		{TransactionID: "1", Actions: "Install", Altered: "420"},
+		{TransactionID: "2", Actions: "Install", Altered: "12"},
+		{TransactionID: "3", Actions: "Upgrade", Altered: "2"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("Entries() returned %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	transaction, err := source.Transaction("3")
+	if err != nil {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+	if transaction.TransactionID != "3" {
+		t.Errorf("TransactionID = %q, want %q", transaction.TransactionID, "3")
+	}
+	if transaction.CommandLine != "upgrade openssl" {
+		t.Errorf("CommandLine = %q, want %q", transaction.CommandLine, "upgrade openssl")
+	}
+	if transaction.BeginTime == "" || transaction.EndTime == "" {
+		t.Errorf("expected begin and end time to be set, got %q and %q", transaction.BeginTime, transaction.EndTime)
+	}
+	if len(transaction.PackagesAltered) != 4 {
+		t.Errorf("PackagesAltered has %d items, want 4", len(transaction.PackagesAltered))
+	}
+
+	if _, err := source.Transaction("2"); err == nil {
+		t.Error("expected an error for a transaction without fixture")
+	}
+}
+
+type stubHistorySource struct {
+	entries []HistoryEntry
+	err     error
+	closed  bool
+}
+
+func (s *stubHistorySource) Entries() ([]HistoryEntry, error) {
+	return s.entries, s.err
+}
+
+func (s *stubHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	return TransactionDetail{TransactionID: transactionID}, s.err
+}
+
+func (s *stubHistorySource) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestFallbackHistorySource(t *testing.T) {
+	primary := &stubHistorySource{err: errTest}
+	fallback := &stubHistorySource{entries: []HistoryEntry{{TransactionID: "7"}}}
+	source := &fallbackHistorySource{primary: primary, fallback: fallback}
+
+	entries, err := source.Entries()
+	if err != nil || len(entries) != 1 || entries[0].TransactionID != "7" {
+		t.Errorf("Entries() = %+v, %v, want the fallback entries", entries, err)
+	}
+
+	if _, err := source.Transaction("7"); err != nil {
+		t.Errorf("Transaction() error = %v, want the fallback result", err)
+	}
+
+	source.Close()
+	if !primary.closed || !fallback.closed {
+		t.Error("Close() should close both sources")
+	}
+}
+
+var errTest = errors.New("test error")
+
+// stubBatchHistorySource returns the odd transactions in batches, leaving the
+// even ones to be read one at a time.
+type stubBatchHistorySource struct {
+	stubHistorySource
+	batches [][]string
+	single  []string
+}
+
+func (s *stubBatchHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	s.single = append(s.single, transactionID)
+	return s.stubHistorySource.Transaction(transactionID)
+}
+
+func (s *stubBatchHistorySource) Transactions(transactionIDs []string) (map[string]TransactionDetail, error) {
+	s.batches = append(s.batches, transactionIDs)
+
+	result := make(map[string]TransactionDetail)
+	for _, transactionID := range transactionIDs {
+		if id, _ := strconv.Atoi(transactionID); id%2 == 1 {
+			result[transactionID] = TransactionDetail{TransactionID: transactionID, Comment: "batch"}
+		}
+	}
+	return result, errTest
+}
+
+func TestEachTransaction(t *testing.T) {
+	source := &stubBatchHistorySource{}
+
+	ids := make([]string, historyBatchSize+2)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i + 1)
+	}
+
+	var got []string
+	err := eachTransaction(source, ids, func(transactionID string, transaction TransactionDetail, err error) error {
+		if err != nil {
+			t.Errorf("transaction %s: unexpected error %v", transactionID, err)
+		}
+		if transaction.TransactionID != transactionID {
+			t.Errorf("transaction %s: got details of %s", transactionID, transaction.TransactionID)
+		}
+		got = append(got, transactionID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("eachTransaction() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, ids) {
+		t.Errorf("eachTransaction() visited %v, want %v", got, ids)
+	}
+	if len(source.batches) != 2 || len(source.batches[0]) != historyBatchSize || len(source.batches[1]) != 2 {
+		t.Errorf("batches = %v, want one of %d and one of 2", source.batches, historyBatchSize)
+	}
+	if len(source.single) != (historyBatchSize+2)/2 {
+		t.Errorf("read %d transactions one at a time, want %d", len(source.single), (historyBatchSize+2)/2)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = eachTransaction(source, ids, func(string, TransactionDetail, error) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("eachTransaction() = %v after %d calls, want it to stop at the first error", err, calls)
+	}
+}
+
+func TestKnownHistorySource(t *testing.T) {
+	source := &stubBatchHistorySource{}
+	known := withKnownTransactions(source, map[string]TransactionDetail{"2": {TransactionID: "2", Comment: "known"}})
+	known.add(map[string]TransactionDetail{"3": {TransactionID: "3", Comment: "known"}})
+
+	found, err := known.Transactions([]string{"1", "2", "3", "4"})
+	if err != errTest {
+		t.Errorf("Transactions() error = %v, want the error of the source", err)
+	}
+	if len(found) != 3 || found["1"].Comment != "batch" || found["2"].Comment != "known" || found["3"].Comment != "known" {
+		t.Errorf("Transactions() = %+v, want 1 from the source and 2 and 3 known", found)
+	}
+	if !reflect.DeepEqual(source.batches, [][]string{{"1", "4"}}) {
+		t.Errorf("batches = %v, want only the unknown transactions read", source.batches)
+	}
+
+	// A known transaction is returned once, then read from the source
+	known.add(map[string]TransactionDetail{"6": {TransactionID: "6", Comment: "known"}})
+	for _, want := range []string{"known", ""} {
+		if details, err := known.Transaction("6"); err != nil || details.TransactionID != "6" || details.Comment != want {
+			t.Errorf("Transaction() = %+v, %v, want comment %q", details, err, want)
+		}
+	}
+	if !reflect.DeepEqual(source.single, []string{"6"}) {
+		t.Errorf("read %v one at a time, want only the second read of 6", source.single)
+	}
+}
@@ -0,0 +1,354 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/txlog/agent/util"
+)
+
+// yumHistoryDir is the directory where yum (EL7) keeps its history databases.
+var yumHistoryDir = "/var/lib/yum/history"
+
+// yumItemStates maps the trans_data_pkgs.state values written by yum to the
+// DNF action names, so that yum and DNF hosts report the same vocabulary.
+var yumItemStates = map[string]dnfItemAction{
+	"True-Install": {"Install", "I"},
+	"Install":      {"Install", "I"},
+	"Dep-Install":  {"Install", "I"},
+	"Downgrade":    {"Downgrade", "D"},
+	"Downgraded":   {"Downgraded", "D"},
+	"Obsoleting":   {"Obsolete", "O"},
+	"Obsoleted":    {"Obsoleted", "O"},
+	"Update":       {"Upgrade", "U"},
+	"Updated":      {"Upgraded", "U"},
+	"Erase":        {"Removed", "E"},
+	"Reinstall":    {"Reinstall", "R"},
+}
+
+// yumItemReasons maps the trans_data_pkgs.state values that tell why a
+// package was installed to the reasons sent to the server.
+var yumItemReasons = map[string]string{
+	"True-Install": reasonUser,
+	"Dep-Install":  reasonDependency,
+}
+
+// yumDBReasons maps the values of the "reason" key of pkg_yumdb to the
+// reasons sent to the server.
+var yumDBReasons = map[string]string{
+	"user": reasonUser,
+	"dep":  reasonDependency,
+}
+
+// yumHistoryDBPath returns the active yum history database in dir. yum starts
+// a new history-<date>.sqlite file on 'yum history new' and only reads the most
+// recent one, so older files are ignored.
+func yumHistoryDBPath(dir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "history-*.sqlite"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no yum history database found in %s", dir)
+	}
+
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
+
+// yumDBHistorySource reads the transaction history from a yum history database.
+type yumDBHistorySource struct {
+	db *sql.DB
+}
+
+// newYumDBHistorySource opens the active yum history database in dir in
+// read-only mode. It returns an error if no database exists or it cannot be read.
+func newYumDBHistorySource(dir string) (*yumDBHistorySource, error) {
+	path, err := yumHistoryDBPath(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openSQLiteReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &yumDBHistorySource{db: db}, nil
+}
+
+// Entries implements HistorySource.
+func (s *yumDBHistorySource) Entries() ([]HistoryEntry, error) {
+	return readYumHistoryEntries(s.db)
+}
+
+// Transaction implements HistorySource.
+func (s *yumDBHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	return readYumHistoryTransaction(s.db, transactionID)
+}
+
+// LatestTransactionID implements latestHistorySource.
+func (s *yumDBHistorySource) LatestTransactionID() (string, error) {
+	var id sql.NullInt64
+	if err := s.db.QueryRow(`SELECT max(tid) FROM trans_beg`).Scan(&id); err != nil {
+		return "", err
+	}
+	if !id.Valid {
+		return "", nil
+	}
+	return strconv.FormatInt(id.Int64, 10), nil
+}
+
+// Close implements HistorySource.
+func (s *yumDBHistorySource) Close() error {
+	return s.db.Close()
+}
+
+// readYumHistoryEntries lists all transactions in the yum history database in
+// ascending order, computing the "Action(s)" and "Altered" columns the same
+// way as readDNFHistoryEntries.
+func readYumHistoryEntries(db *sql.DB) ([]HistoryEntry, error) {
+	rows, err := db.Query(`
+		SELECT b.tid, d.state
+		FROM trans_beg b
+		LEFT JOIN trans_data_pkgs d ON d.tid = b.tid
+		ORDER BY b.tid, d.pkgtupid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]HistoryEntry, 0)
+	var ids []int64
+	actions := make(map[int64]map[string]string)
+	altered := make(map[int64]int)
+
+	for rows.Next() {
+		var id int64
+		var state sql.NullString
+		if err := rows.Scan(&id, &state); err != nil {
+			return nil, err
+		}
+
+		if _, seen := actions[id]; !seen {
+			ids = append(ids, id)
+			actions[id] = make(map[string]string)
+		}
+
+		// The replaced side of upgrades and downgrades is not listed
+		if !state.Valid || state.String == "Updated" || state.String == "Downgraded" {
+			continue
+		}
+
+		a, ok := yumItemStates[state.String]
+		if !ok {
+			a = dnfItemAction{name: state.String, short: "?"}
+		}
+		actions[id][a.name] = a.short
+		altered[id]++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		entries = append(entries, HistoryEntry{
+			TransactionID: strconv.FormatInt(id, 10),
+			Actions:       summarizeActions(actions[id]),
+			Altered:       strconv.Itoa(altered[id]),
+		})
+	}
+
+	return entries, nil
+}
+
+// readYumHistoryTransaction reads a single transaction from the yum history
+// database and maps it to a TransactionDetail.
+func readYumHistoryTransaction(db *sql.DB, transactionID string) (TransactionDetail, error) {
+	if !reValidInput.MatchString(transactionID) {
+		return TransactionDetail{}, fmt.Errorf("invalid input")
+	}
+
+	begin, err := queryRowMap(db, "SELECT * FROM trans_beg WHERE tid = ?", transactionID)
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+	if begin == nil {
+		return TransactionDetail{}, fmt.Errorf("transaction %s not found in yum history", transactionID)
+	}
+
+	transaction := TransactionDetail{
+		TransactionID: transactionID,
+		BeginRPMDB:    columnString(begin, "rpmdb_version"),
+		User:          util.UserDisplayName(columnInt(begin, "loginuid")),
+	}
+	if timestamp := columnInt(begin, "timestamp"); timestamp > 0 {
+		transaction.BeginTime = util.FormatTimestamp(timestamp)
+	}
+
+	end, err := queryRowMap(db, "SELECT * FROM trans_end WHERE tid = ?", transactionID)
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+	switch {
+	case end == nil:
+		// yum never recorded the end of this transaction
+		transaction.ReturnCode = "** Aborted **"
+	case columnInt(end, "return_code") == 0:
+		transaction.ReturnCode = "Success"
+	default:
+		transaction.ReturnCode = "Failure: " + columnString(end, "return_code")
+	}
+	if end != nil {
+		transaction.EndRPMDB = columnString(end, "rpmdb_version")
+		if timestamp := columnInt(end, "timestamp"); timestamp > 0 {
+			transaction.EndTime = util.FormatTimestamp(timestamp)
+		}
+	}
+
+	// trans_cmdline only exists on databases created by yum >= 3.2.28
+	if cmdline, err := queryRowMap(db, "SELECT cmdline FROM trans_cmdline WHERE tid = ?", transactionID); err == nil && cmdline != nil {
+		transaction.CommandLine = columnString(cmdline, "cmdline")
+	}
+
+	transaction.PackagesAltered, transaction.Releasever, err = readYumHistoryItems(db, transactionID)
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+
+	transaction.ScriptletOutput, err = readYumHistoryOutput(db, transactionID)
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+
+	transaction.Errors, err = readYumHistoryErrors(db, transactionID)
+	if err != nil {
+		return TransactionDetail{}, err
+	}
+	completeTransactionDetail(&transaction)
+
+	return transaction, nil
+}
+
+// readYumHistoryItems returns the packages altered by a transaction and the
+// releasever they were installed with. yum stores the repository, the
+// releasever and the install reason per package in pkg_yumdb, which may be
+// absent on old databases. The reason is taken from the transaction state
+// when it tells one (True-Install or Dep-Install).
+func readYumHistoryItems(db *sql.DB, transactionID string) ([]Package, string, error) {
+	yumdb := hasTable(db, "pkg_yumdb")
+
+	query := `
+		SELECT d.state, p.name, p.epoch, p.version, p.release, p.arch, '', '', ''
+		FROM trans_data_pkgs d
+		JOIN pkgtups p ON p.pkgtupid = d.pkgtupid
+		WHERE d.tid = ?
+		ORDER BY d.pkgtupid`
+	if yumdb {
+		query = `
+		SELECT d.state, p.name, p.epoch, p.version, p.release, p.arch,
+			COALESCE((SELECT yumdb_val FROM pkg_yumdb y WHERE y.pkgtupid = p.pkgtupid AND y.yumdb_key = 'from_repo'), ''),
+			COALESCE((SELECT yumdb_val FROM pkg_yumdb y WHERE y.pkgtupid = p.pkgtupid AND y.yumdb_key = 'releasever'), ''),
+			COALESCE((SELECT yumdb_val FROM pkg_yumdb y WHERE y.pkgtupid = p.pkgtupid AND y.yumdb_key = 'reason'), '')
+		FROM trans_data_pkgs d
+		JOIN pkgtups p ON p.pkgtupid = d.pkgtupid
+		WHERE d.tid = ?
+		ORDER BY d.pkgtupid`
+	}
+
+	rows, err := db.Query(query, transactionID)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var packages []Package
+	var releasever string
+	for rows.Next() {
+		var state, epoch, pkgReleasever, pkgReason string
+		var pkg Package
+		if err := rows.Scan(&state, &pkg.Name, &epoch, &pkg.Version, &pkg.Release, &pkg.Arch, &pkg.Repo, &pkgReleasever, &pkgReason); err != nil {
+			return nil, "", err
+		}
+
+		if a, ok := yumItemStates[state]; ok {
+			pkg.Action = a.name
+		} else {
+			pkg.Action = state
+		}
+		if reason, ok := yumItemReasons[state]; ok {
+			pkg.Reason = reason
+		} else {
+			pkg.Reason = yumDBReasons[pkgReason]
+		}
+		// As in 'yum history info', the version is prefixed with the epoch
+		if epoch != "0" && epoch != "" {
+			pkg.Epoch = epoch
+			pkg.Version = epoch + ":" + pkg.Version
+		}
+		if releasever == "" {
+			releasever = pkgReleasever
+		}
+
+		packages = append(packages, pkg)
+	}
+
+	return packages, releasever, rows.Err()
+}
+
+// readYumHistoryOutput returns the scriptlet output recorded for a transaction.
+func readYumHistoryOutput(db *sql.DB, transactionID string) ([]string, error) {
+	rows, err := db.Query("SELECT line FROM trans_script_stdout WHERE tid = ? ORDER BY lid", transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var output []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		output = append(output, strings.TrimRight(line, "\n"))
+	}
+
+	return output, rows.Err()
+}
+
+// readYumHistoryErrors returns the errors recorded for a transaction. The
+// trans_error table only exists on databases created by yum >= 3.2.28.
+func readYumHistoryErrors(db *sql.DB, transactionID string) ([]string, error) {
+	if !hasTable(db, "trans_error") {
+		return nil, nil
+	}
+
+	rows, err := db.Query("SELECT msg FROM trans_error WHERE tid = ? ORDER BY mid", transactionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var errors []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		errors = append(errors, strings.TrimRight(msg, "\n"))
+	}
+
+	return errors, rows.Err()
+}
+
+// hasTable reports whether a table exists in the database.
+func hasTable(db *sql.DB, table string) bool {
+	var name string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&name)
+	return err == nil
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// yumHistorySchema mirrors the tables created by yum's history module.
+const yumHistorySchema = `
+CREATE TABLE trans_beg (tid INTEGER PRIMARY KEY, timestamp INTEGER NOT NULL, rpmdb_version TEXT NOT NULL, loginuid INTEGER);
+CREATE TABLE trans_end (tid INTEGER PRIMARY KEY, timestamp INTEGER NOT NULL, rpmdb_version TEXT NOT NULL, return_code INTEGER NOT NULL);
+CREATE TABLE trans_cmdline (tid INTEGER NOT NULL, cmdline TEXT NOT NULL);
+CREATE TABLE trans_data_pkgs (tid INTEGER NOT NULL, pkgtupid INTEGER NOT NULL, done BOOL NOT NULL DEFAULT FALSE, state TEXT NOT NULL);
+CREATE TABLE trans_script_stdout (lid INTEGER PRIMARY KEY, tid INTEGER NOT NULL, line TEXT NOT NULL);
+CREATE TABLE trans_error (mid INTEGER PRIMARY KEY, tid INTEGER NOT NULL, msg TEXT NOT NULL);
+CREATE TABLE pkgtups (pkgtupid INTEGER PRIMARY KEY, name TEXT NOT NULL, arch TEXT NOT NULL, epoch TEXT NOT NULL, version TEXT NOT NULL, release TEXT NOT NULL, checksum TEXT);
+CREATE TABLE pkg_yumdb (pkgtupid INTEGER NOT NULL, yumdb_key TEXT NOT NULL, yumdb_val TEXT NOT NULL);
+
+INSERT INTO pkgtups VALUES
+	(1, 'bash', 'x86_64', '0', '4.2.46', '34.el7', NULL),
+	(2, 'bash', 'x86_64', '0', '4.2.46', '35.el7_9', NULL),
+	(3, 'httpd', 'x86_64', '0', '2.4.6', '99.el7', NULL),
+	(4, 'openssl-libs', 'x86_64', '1', '1.0.2k', '26.el7_9', NULL);
+INSERT INTO pkg_yumdb VALUES
+	(1, 'from_repo', 'anaconda'),
+	(2, 'from_repo', 'updates'),
+	(2, 'releasever', '7'),
+	(2, 'reason', 'user'),
+	(3, 'from_repo', 'base'),
+	(3, 'releasever', '7'),
+	(4, 'from_repo', 'base');
+
+INSERT INTO trans_beg VALUES (1, 1600000000, '100:aaa', 0), (2, 1600100000, '101:bbb', 1000), (3, 1600200000, '103:ccc', 0);
+INSERT INTO trans_end VALUES (1, 1600000030, '101:bbb', 0), (2, 1600100050, '103:ccc', 1);
+INSERT INTO trans_cmdline VALUES (1, 'update bash'), (2, 'install httpd');
+INSERT INTO trans_data_pkgs VALUES
+	(1, 1, 'TRUE', 'Updated'),
+	(1, 2, 'TRUE', 'Update'),
+	(2, 3, 'TRUE', 'True-Install'),
+	(2, 4, 'TRUE', 'Dep-Install');
+INSERT INTO trans_error VALUES (1, 2, 'httpd: %pre scriptlet failed');
+INSERT INTO trans_script_stdout VALUES (1, 2, 'warning: httpd.conf created as httpd.conf.rpmnew');
+`
+
+// newTestYumHistoryDB creates a yum history directory with an outdated and a
+// current database, and returns a source reading it.
+func newTestYumHistoryDB(t *testing.T) *yumDBHistorySource {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "history-2019-01-01.sqlite"), []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("failed to create outdated database: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(dir, "history-2020-09-13.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(yumHistorySchema); err != nil {
+		t.Fatalf("failed to populate database: %v", err)
+	}
+
+	source, err := newYumDBHistorySource(dir)
+	if err != nil {
+		t.Fatalf("newYumDBHistorySource() error = %v", err)
+	}
+	t.Cleanup(func() { source.Close() })
+
+	return source
+}
+
+func TestReadYumHistoryEntries(t *testing.T) {
+	source := newTestYumHistoryDB(t)
+
+	entries, err := source.Entries()
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+
+	want := []HistoryEntry{
+		{TransactionID: "1", Actions: "Upgrade", Altered: "1"},
+		{TransactionID: "2", Actions: "Install", Altered: "2"},
+		{TransactionID: "3", Actions: "", Altered: "0"},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("Entries() returned %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestReadYumHistoryTransaction(t *testing.T) {
+	source := newTestYumHistoryDB(t)
+
+	tests := []struct {
+		id             string
+		wantReturnCode string
+		wantStatus     string
+		wantError      string
+		wantCmdline    string
+		wantReleasever string
+		wantPackages   []Package
+	}{
+		{
+			id:             "1",
+			wantReturnCode: "Success",
+			wantStatus:     "success",
+			wantCmdline:    "update bash",
+			wantReleasever: "7",
+			wantPackages: []Package{
+				{Action: "Upgraded", Name: "bash", Version: "4.2.46", Release: "34.el7", Arch: "x86_64", Repo: "anaconda"},
+				{Action: "Upgrade", Name: "bash", Version: "4.2.46", Release: "35.el7_9", Arch: "x86_64", Repo: "updates", Reason: "user"},
+			},
+		},
+		{
+			id:             "2",
+			wantReturnCode: "Failure: 1",
+			wantStatus:     "failure",
+			wantError:      "httpd: %pre scriptlet failed",
+			wantCmdline:    "install httpd",
+			wantReleasever: "7",
+			wantPackages: []Package{
+				{Action: "Install", Name: "httpd", Version: "2.4.6", Release: "99.el7", Arch: "x86_64", Repo: "base", Reason: "user"},
+				{Action: "Install", Name: "openssl-libs", Version: "1:1.0.2k", Release: "26.el7_9", Epoch: "1", Arch: "x86_64", Repo: "base", Reason: "dependency"},
+			},
+		},
+		{
+			id:             "3",
+			wantReturnCode: "** Aborted **",
+			wantStatus:     "incomplete",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			transaction, err := source.Transaction(tt.id)
+			if err != nil {
+				t.Fatalf("Transaction() error = %v", err)
+			}
+
+			if transaction.ReturnCode != tt.wantReturnCode {
+				t.Errorf("ReturnCode = %q, want %q", transaction.ReturnCode, tt.wantReturnCode)
+			}
+			if transaction.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", transaction.Status, tt.wantStatus)
+			}
+			if transaction.ErrorMessage != tt.wantError {
+				t.Errorf("ErrorMessage = %q, want %q", transaction.ErrorMessage, tt.wantError)
+			}
+			if transaction.CommandLine != tt.wantCmdline {
+				t.Errorf("CommandLine = %q, want %q", transaction.CommandLine, tt.wantCmdline)
+			}
+			if transaction.Releasever != tt.wantReleasever {
+				t.Errorf("Releasever = %q, want %q", transaction.Releasever, tt.wantReleasever)
+			}
+			if len(transaction.PackagesAltered) != len(tt.wantPackages) {
+				t.Fatalf("PackagesAltered has %d items, want %d", len(transaction.PackagesAltered), len(tt.wantPackages))
+			}
+			for i := range tt.wantPackages {
+				if transaction.PackagesAltered[i] != tt.wantPackages[i] {
+					t.Errorf("package %d = %+v, want %+v", i, transaction.PackagesAltered[i], tt.wantPackages[i])
+				}
+			}
+		})
+	}
+}
+
+func TestYumDBLatestTransactionID(t *testing.T) {
+	source := newTestYumHistoryDB(t)
+
+	transactionID, err := latestTransactionID(source)
+	if err != nil {
+		t.Fatalf("latestTransactionID() error = %v", err)
+	}
+	if transactionID != "3" {
+		t.Errorf("latestTransactionID() = %q, want \"3\"", transactionID)
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// idempotencyKeyHeader carries the idempotency key of an upload, so that the
+// server can recognize a retried or replayed upload it already saved. The key
+// is also sent in the body, as "idempotency_key", where batches need it.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// transactionIdempotencyKey identifies a transaction of a machine by its ID
+// and the rpmdb checksums it began and ended with. The key is the same on
+// every upload of the transaction, but a transaction ID reused after the
+// history was reset gets a different one.
+func transactionIdempotencyKey(machineId string, details TransactionDetail) string {
+	return hashKey(machineId, details.TransactionID, details.BeginRPMDB, details.EndRPMDB)
+}
+
+// executionIdempotencyKey identifies an execution report by its content,
+// which includes the machine ID and the time of the execution.
+func executionIdempotencyKey(body map[string]interface{}) string {
+	// Map keys are sorted by json.Marshal, so the encoding is stable
+	data, err := json.Marshal(body)
+	if err != nil {
+		return ""
+	}
+	return hashKey(string(data))
+}
+
+// batchIdempotencyKey identifies a batch by the keys of its transactions.
+func batchIdempotencyKey(bodies []map[string]interface{}) string {
+	keys := make([]string, len(bodies))
+	for i, body := range bodies {
+		keys[i] = idempotencyKey(body)
+	}
+	return hashKey(keys...)
+}
+
+// hashKey returns the hex-encoded SHA-256 of parts, separated so that moving
+// characters from one part to the next changes the key.
+func hashKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyKey returns the idempotency key carried by an upload body, a
+// payload map or its JSON encoding, or "" when it has none.
+func idempotencyKey(body interface{}) string {
+	switch body := body.(type) {
+	case map[string]interface{}:
+		key, _ := body["idempotency_key"].(string)
+		return key
+	case []byte:
+		var payload struct {
+			IdempotencyKey string `json:"idempotency_key"`
+		}
+		if json.Unmarshal(body, &payload) != nil {
+			return ""
+		}
+		return payload.IdempotencyKey
+	}
+	return ""
+}
+
+// setIdempotencyKey sends the idempotency key of body, if any, in the
+// Idempotency-Key header of request.
+func setIdempotencyKey(request *resty.Request, body interface{}) *resty.Request {
+	if key := idempotencyKey(body); key != "" {
+		request.SetHeader(idempotencyKeyHeader, key)
+	}
+	return request
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/txlog/agent/internal/spool"
+	"github.com/txlog/agent/util"
+)
+
+func TestTransactionIdempotencyKey(t *testing.T) {
+	details := TransactionDetail{TransactionID: "7", BeginRPMDB: "100:aaa", EndRPMDB: "101:bbb"}
+	key := transactionIdempotencyKey("machine", details)
+
+	if key != transactionIdempotencyKey("machine", details) {
+		t.Error("transactionIdempotencyKey() should be the same on every upload")
+	}
+
+	reset := details
+	reset.EndRPMDB = "101:ccc"
+	if key == transactionIdempotencyKey("machine", reset) {
+		t.Error("transactionIdempotencyKey() should differ for a reused transaction ID")
+	}
+	if key == transactionIdempotencyKey("other", details) {
+		t.Error("transactionIdempotencyKey() should differ between machines")
+	}
+
+	body := transactionPayload(HistoryEntry{TransactionID: "7"}, details, "machine", "host")
+	if body["idempotency_key"] != key {
+		t.Errorf("transactionPayload() idempotency_key = %v, want %s", body["idempotency_key"], key)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		body interface{}
+		want string
+	}{
+		{map[string]interface{}{"idempotency_key": "abc"}, "abc"},
+		{map[string]interface{}{"transaction_id": "1"}, ""},
+		{[]byte(`{"transaction_id":"1","idempotency_key":"abc"}`), "abc"},
+		{[]byte(`{"transaction_id":"1"}`), ""},
+		{[]byte(`not json`), ""},
+	}
+
+	for _, tt := range tests {
+		if got := idempotencyKey(tt.body); got != tt.want {
+			t.Errorf("idempotencyKey(%v) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestIdempotencyKeyHeader(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get(idempotencyKeyHeader))
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	if err := sendTransaction(util.NewServerClient(), map[string]interface{}{"idempotency_key": "posted"}, minMaxBodySize); err != nil {
+		t.Fatalf("sendTransaction() error = %v", err)
+	}
+
+	sp, err := spool.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sp.Add(spool.KindTransaction, "3", []byte(`{"transaction_id":"3","idempotency_key":"replayed"}`))
+	if _, _, err := replaySpool(sp, map[string]struct{}{}, 0); err != nil {
+		t.Fatalf("replaySpool() error = %v", err)
+	}
+
+	if len(received) != 2 || received[0] != "posted" || received[1] != "replayed" {
+		t.Errorf("server received keys %q, want the key of each body", received)
+	}
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import "strings"
+
+// PackageChange links the two sides of a package replacement, such as the
+// Upgrade and Upgraded lines of 'dnf history info', into a single record that
+// tells which version a package moved from and to.
+type PackageChange struct {
+	Action   string `json:"action"`
+	Name     string `json:"name"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	FromRepo string `json:"from_repo"`
+	ToRepo   string `json:"to_repo"`
+}
+
+// replacedActions maps the action of a new package to the action of the
+// package it replaced.
+var replacedActions = map[string]string{
+	"Upgrade":   "Upgraded",
+	"Downgrade": "Downgraded",
+	"Obsolete":  "Obsoleted",
+	"Reinstall": "Reinstalled",
+}
+
+// NEVRA returns the package name in the name-[epoch:]version-release.arch
+// format used by rpm. The version of packages parsed from 'dnf history info'
+// already holds the epoch, as in "1:3.0.7".
+func (p Package) NEVRA() string {
+	nevra := p.Name + "-"
+	if p.Epoch != "" && p.Epoch != "0" && !strings.Contains(p.Version, ":") {
+		nevra += p.Epoch + ":"
+	}
+	nevra += p.Version + "-" + p.Release
+	if p.Arch != "" {
+		nevra += "." + p.Arch
+	}
+	return nevra
+}
+
+// sourceRepo returns the repository a package came from: FromRepo for the
+// replaced side of an upgrade or downgrade, when the history source tells it
+// apart, or Repo.
+func (p Package) sourceRepo() string {
+	if p.FromRepo != "" {
+		return p.FromRepo
+	}
+	return p.Repo
+}
+
+// linkPackageChanges pairs every upgrade, downgrade, obsolete and reinstall in
+// packages with the package it replaced. Packages are matched by name and
+// architecture, then by name alone, as the architecture may change (for
+// example, from x86_64 to noarch). An obsoleted package usually has another
+// name, so it is linked to the obsoleting package of the transaction when
+// there is only one.
+//
+// Changes are returned in the order of the new packages. A side that cannot
+// be paired is still reported, with the other side left empty.
+func linkPackageChanges(packages []Package) []PackageChange {
+	oldSides := make(map[string][]int)
+	for i, p := range packages {
+		for _, replaced := range replacedActions {
+			if p.Action == replaced {
+				oldSides[replaced] = append(oldSides[replaced], i)
+			}
+		}
+	}
+
+	obsoleting := 0
+	for _, p := range packages {
+		if p.Action == "Obsolete" {
+			obsoleting++
+		}
+	}
+
+	var changes []PackageChange
+	linked := make(map[int]bool)
+	for _, p := range packages {
+		replaced, ok := replacedActions[p.Action]
+		if !ok {
+			continue
+		}
+
+		change := PackageChange{Action: p.Action, Name: p.Name, To: p.NEVRA(), ToRepo: p.Repo}
+
+		match := -1
+		for _, sameArch := range []bool{true, false} {
+			for _, i := range oldSides[replaced] {
+				old := packages[i]
+				if !linked[i] && old.Name == p.Name && (!sameArch || old.Arch == p.Arch) {
+					match = i
+					break
+				}
+			}
+			if match >= 0 {
+				break
+			}
+		}
+
+		if match >= 0 {
+			linked[match] = true
+			change.From = packages[match].NEVRA()
+			change.FromRepo = packages[match].sourceRepo()
+			changes = append(changes, change)
+		}
+
+		if p.Action == "Obsolete" && obsoleting == 1 {
+			for _, i := range oldSides[replaced] {
+				if linked[i] {
+					continue
+				}
+				linked[i] = true
+				obsoleted := change
+				obsoleted.From = packages[i].NEVRA()
+				obsoleted.FromRepo = packages[i].sourceRepo()
+				changes = append(changes, obsoleted)
+				match = i
+			}
+		}
+
+		if match < 0 {
+			changes = append(changes, change)
+		}
+	}
+
+	for _, replaced := range []string{"Upgraded", "Downgraded", "Obsoleted", "Reinstalled"} {
+		for _, i := range oldSides[replaced] {
+			if linked[i] {
+				continue
+			}
+			old := packages[i]
+			changes = append(changes, PackageChange{
+				Action:   old.Action,
+				Name:     old.Name,
+				From:     old.NEVRA(),
+				FromRepo: old.sourceRepo(),
+			})
+		}
+	}
+
+	return changes
+}
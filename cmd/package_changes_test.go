@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPackageNEVRA(t *testing.T) {
+	tests := []struct {
+		pkg  Package
+		want string
+	}{
+		{Package{Name: "openssl", Epoch: "1", Version: "3.0.7", Release: "27.el9", Arch: "x86_64"}, "openssl-1:3.0.7-27.el9.x86_64"},
+		{Package{Name: "git", Epoch: "0", Version: "2.39.3", Release: "1.el9", Arch: "x86_64"}, "git-2.39.3-1.el9.x86_64"},
+		{Package{Name: "tzdata", Version: "2024a", Release: "1.el9", Arch: "noarch"}, "tzdata-2024a-1.el9.noarch"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.pkg.NEVRA(); got != tt.want {
+			t.Errorf("NEVRA() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestLinkPackageChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		packages []Package
+		want     []PackageChange
+	}{
+		{
+			name: "upgrade",
+			packages: []Package{
+				{Action: "Install", Name: "git", Version: "2.39.3", Release: "1.el9", Arch: "x86_64", Repo: "appstream"},
+				{Action: "Upgrade", Name: "openssl", Epoch: "1", Version: "3.0.7", Release: "27.el9", Arch: "x86_64", Repo: "baseos"},
+				{Action: "Upgraded", Name: "openssl", Epoch: "1", Version: "3.0.7", Release: "24.el9", Arch: "x86_64", Repo: "@System"},
+			},
+			want: []PackageChange{
+				{Action: "Upgrade", Name: "openssl", From: "openssl-1:3.0.7-24.el9.x86_64", To: "openssl-1:3.0.7-27.el9.x86_64", FromRepo: "@System", ToRepo: "baseos"},
+			},
+		},
+		{
+			name: "downgrade with architecture change",
+			packages: []Package{
+				{Action: "Downgraded", Name: "tzdata", Version: "2024a", Release: "1.el9", Arch: "x86_64", Repo: "@System"},
+				{Action: "Downgrade", Name: "tzdata", Version: "2023c", Release: "1.el9", Arch: "noarch", Repo: "baseos"},
+			},
+			want: []PackageChange{
+				{Action: "Downgrade", Name: "tzdata", From: "tzdata-2024a-1.el9.x86_64", To: "tzdata-2023c-1.el9.noarch", FromRepo: "@System", ToRepo: "baseos"},
+			},
+		},
+		{
+			name: "multilib upgrade",
+			packages: []Package{
+				{Action: "Upgrade", Name: "glibc", Version: "2.34", Release: "100.el9", Arch: "i686", Repo: "baseos"},
+				{Action: "Upgrade", Name: "glibc", Version: "2.34", Release: "100.el9", Arch: "x86_64", Repo: "baseos"},
+				{Action: "Upgraded", Name: "glibc", Version: "2.34", Release: "83.el9", Arch: "x86_64", Repo: "@System"},
+				{Action: "Upgraded", Name: "glibc", Version: "2.34", Release: "83.el9", Arch: "i686", Repo: "@System"},
+			},
+			want: []PackageChange{
+				{Action: "Upgrade", Name: "glibc", From: "glibc-2.34-83.el9.i686", To: "glibc-2.34-100.el9.i686", FromRepo: "@System", ToRepo: "baseos"},
+				{Action: "Upgrade", Name: "glibc", From: "glibc-2.34-83.el9.x86_64", To: "glibc-2.34-100.el9.x86_64", FromRepo: "@System", ToRepo: "baseos"},
+			},
+		},
+		{
+			name: "obsolete with another name",
+			packages: []Package{
+				{Action: "Obsolete", Name: "python3-dnf", Version: "4.14.0", Release: "1.el9", Arch: "noarch", Repo: "baseos"},
+				{Action: "Obsoleted", Name: "python3-dnf-plugin-foo", Version: "1.0", Release: "1.el9", Arch: "noarch", Repo: "@System"},
+				{Action: "Obsoleted", Name: "python3-dnf-plugin-bar", Version: "1.0", Release: "1.el9", Arch: "noarch", Repo: "@System"},
+			},
+			want: []PackageChange{
+				{Action: "Obsolete", Name: "python3-dnf", From: "python3-dnf-plugin-foo-1.0-1.el9.noarch", To: "python3-dnf-4.14.0-1.el9.noarch", FromRepo: "@System", ToRepo: "baseos"},
+				{Action: "Obsolete", Name: "python3-dnf", From: "python3-dnf-plugin-bar-1.0-1.el9.noarch", To: "python3-dnf-4.14.0-1.el9.noarch", FromRepo: "@System", ToRepo: "baseos"},
+			},
+		},
+		{
+			name: "reinstall",
+			packages: []Package{
+				{Action: "Reinstall", Name: "bash", Version: "5.1.8", Release: "6.el9", Arch: "x86_64", Repo: "baseos"},
+				{Action: "Reinstalled", Name: "bash", Version: "5.1.8", Release: "6.el9", Arch: "x86_64", Repo: "@System"},
+			},
+			want: []PackageChange{
+				{Action: "Reinstall", Name: "bash", From: "bash-5.1.8-6.el9.x86_64", To: "bash-5.1.8-6.el9.x86_64", FromRepo: "@System", ToRepo: "baseos"},
+			},
+		},
+		{
+			name: "unpaired sides",
+			packages: []Package{
+				{Action: "Upgrade", Name: "vim-enhanced", Version: "9.0", Release: "1.el9", Arch: "x86_64", Repo: "appstream"},
+				{Action: "Upgraded", Name: "curl", Version: "7.76.1", Release: "26.el9", Arch: "x86_64", Repo: "@System"},
+			},
+			want: []PackageChange{
+				{Action: "Upgrade", Name: "vim-enhanced", To: "vim-enhanced-9.0-1.el9.x86_64", ToRepo: "appstream"},
+				{Action: "Upgraded", Name: "curl", From: "curl-7.76.1-26.el9.x86_64", FromRepo: "@System"},
+			},
+		},
+		{
+			name: "no replacements",
+			packages: []Package{
+				{Action: "Install", Name: "git", Version: "2.39.3", Release: "1.el9", Arch: "x86_64", Repo: "appstream"},
+				{Action: "Removed", Name: "nano", Version: "5.6.1", Release: "5.el9", Arch: "x86_64", Repo: "@System"},
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := linkPackageChanges(tt.packages); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("linkPackageChanges() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/cobra"
+	"github.com/txlog/agent/util"
+)
+
+// errReplaceUnsupported is returned when the server does not replace saved
+// transactions on PUT /v1/transactions.
+var errReplaceUnsupported = errors.New("server does not support replacing transactions")
+
+var repairCmd = &cobra.Command{
+	Use:   "repair",
+	Short: "Resend the transactions that verify finds missing or different on the server",
+	Long: `
+This command runs the same checks as 'txlog verify' and resends only the
+affected transactions:
+  - Transactions that exist locally but not on the server are sent again
+  - Transactions with missing or extra items, or with fields that differ, on
+    the server are replaced, items included, on servers that support it
+
+The repaired transactions are then verified again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		machineId, err := util.GetMachineId()
+		if err != nil {
+			color.Red("Error getting machine ID: %v", err)
+			os.Exit(1)
+		}
+
+		hostname, err := util.GetHostname()
+		if err != nil {
+			color.Red("Error getting hostname: %v", err)
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stdout, "Repairing data for %s\n", color.CyanString(hostname))
+		fmt.Fprintf(os.Stdout, "Machine ID: %s\n\n", color.CyanString(machineId))
+
+		source, err := openHistorySource(historySourceSpec(cmd))
+		if err != nil {
+			color.Red("Error opening transaction history: %v", err)
+			os.Exit(1)
+		}
+		defer source.Close()
+
+		result, err := verifyDataIntegrity(source, machineId, hostname)
+		if err != nil {
+			color.Red("Error during verification: %v", err)
+			os.Exit(1)
+		}
+
+		affected := affectedTransactions(result)
+		if len(affected) == 0 {
+			color.Green("✓ Nothing to repair, data integrity verified successfully!")
+			return
+		}
+
+		fmt.Fprintf(os.Stdout, "Repairing %s transactions...\n", color.YellowString("%d", len(affected)))
+		failures, err := repairTransactions(source, machineId, hostname, result, uploadMaxBodySize())
+		if err != nil {
+			color.Red("Error during repair: %v", err)
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stdout)
+
+		// * verifies the repaired transactions again, as the server has the last word on what it saved
+		fmt.Fprintf(os.Stdout, "Verifying repaired transactions...\n")
+		scope := make(map[int]bool, len(affected))
+		for _, transactionID := range affected {
+			id, _ := strconv.Atoi(transactionID)
+			scope[id] = true
+		}
+		after, err := verifyTransactions(source, machineId, hostname, verifyOptions{inScope: func(id int) bool { return scope[id] }})
+		if err != nil {
+			color.Red("Error during verification: %v", err)
+			os.Exit(1)
+		}
+
+		printRepairResults(affected, failures, after)
+
+		if len(failures) > 0 || !verified(after) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	addHistorySourceFlag(repairCmd)
+	rootCmd.AddCommand(repairCmd)
+}
+
+// affectedTransactions returns the IDs of the transactions with an issue in
+// result, once each, in ascending order.
+func affectedTransactions(result *VerificationResult) []string {
+	var affected []string
+	for _, ids := range [][]string{result.MissingOnServer, result.WithMissingItems, result.WithExtraItems, result.WithFieldMismatches} {
+		for _, id := range ids {
+			if !slices.Contains(affected, id) {
+				affected = append(affected, id)
+			}
+		}
+	}
+
+	slices.SortFunc(affected, func(a, b string) int {
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x - y
+	})
+	return affected
+}
+
+// repairTransactions resends the transactions with an issue in result: the
+// ones missing on the server are sent again, and the ones with missing or
+// extra items or different fields replace the server's copy, on servers that
+// support it. Either way, transactions larger than maxBodySize bytes are sent
+// in chunks.
+//
+// Returns:
+//   - []transactionFailure: transactions that could not be repaired, in ascending order
+//   - error: any error that stopped the repair
+func repairTransactions(source HistorySource, machineId, hostname string, result *VerificationResult, maxBodySize int) ([]transactionFailure, error) {
+	entries, err := source.Entries()
+	if err != nil {
+		return nil, err
+	}
+	entriesByID := make(map[string]HistoryEntry, len(entries))
+	for _, entry := range entries {
+		entriesByID[entry.TransactionID] = entry
+	}
+
+	client := util.NewServerClient()
+
+	var failures []transactionFailure
+	err = eachTransaction(source, affectedTransactions(result), func(transactionID string, details TransactionDetail, err error) error {
+		if err == nil {
+			body := transactionPayload(entriesByID[transactionID], details, machineId, hostname)
+			if slices.Contains(result.MissingOnServer, transactionID) {
+				err = sendTransaction(client, body, maxBodySize)
+			} else {
+				err = putTransaction(client, body, maxBodySize)
+			}
+		}
+
+		if err != nil {
+			failures = append(failures, transactionFailure{TransactionID: transactionID, Err: err})
+			color.Red("  ✗ Transaction #%s could not be repaired: %v", transactionID, err)
+			return nil
+		}
+
+		color.Green("  ✓ Transaction #%s resent", transactionID)
+		return nil
+	})
+
+	return failures, err
+}
+
+// putTransaction replaces a transaction saved on the server, items included,
+// in chunks when it is larger than maxSize bytes. It returns a
+// ServerUnavailableError when the server could not be reached, and
+// errReplaceUnsupported when it does not replace transactions.
+func putTransaction(client *resty.Client, body map[string]interface{}, maxSize int) error {
+	transaction, err := encodeTransaction(body, maxSize)
+	if err != nil {
+		return err
+	}
+
+	err = transaction.send(client, resty.MethodPut)
+	var status *ServerStatusError
+	if errors.As(err, &status) && endpointUnsupported(status.StatusCode) {
+		return errReplaceUnsupported
+	}
+	return err
+}
+
+// printRepairResults prints a summary of the repair and of the verification
+// of the repaired transactions.
+func printRepairResults(affected []string, failures []transactionFailure, after *VerificationResult) {
+	fmt.Fprintln(os.Stdout, strings.Repeat("=", 60))
+	fmt.Fprintln(os.Stdout, "REPAIR SUMMARY")
+	fmt.Fprintln(os.Stdout, strings.Repeat("=", 60))
+	fmt.Fprintf(os.Stdout, "Affected transactions:     %s\n", color.CyanString("%d", len(affected)))
+	fmt.Fprintf(os.Stdout, "Resent:                    %s\n", color.GreenString("%d", len(affected)-len(failures)))
+	if len(failures) > 0 {
+		fmt.Fprintf(os.Stdout, "Failed:                    %s\n", color.RedString("%d", len(failures)))
+	} else {
+		fmt.Fprintf(os.Stdout, "Failed:                    %s\n", color.GreenString("0"))
+	}
+	fmt.Fprintf(os.Stdout, "Verified after repair:     %s\n", color.GreenString("%d", after.FullyVerified))
+	fmt.Fprintln(os.Stdout, strings.Repeat("=", 60))
+
+	if len(failures) == 0 && verified(after) {
+		color.Green("\n✓ All affected transactions repaired successfully!")
+	} else {
+		color.Red("\n✗ Some transactions could not be repaired!")
+		for _, failure := range failures {
+			fmt.Fprintf(os.Stdout, "  #%s: %v\n", failure.TransactionID, failure.Err)
+		}
+		for _, ids := range [][]string{after.MissingOnServer, after.WithMissingItems, after.WithExtraItems, after.WithFieldMismatches} {
+			for _, id := range ids {
+				if !slices.ContainsFunc(failures, func(f transactionFailure) bool { return f.TransactionID == id }) {
+					fmt.Fprintf(os.Stdout, "  #%s: still differs on the server after being resent\n", id)
+				}
+			}
+		}
+	}
+	fmt.Fprintln(os.Stdout)
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestAffectedTransactions(t *testing.T) {
+	result := &VerificationResult{
+		MissingOnServer:  []string{"12", "3"},
+		WithMissingItems: []string{"7", "20"},
+		WithExtraItems:   []string{"20", "5"},
+	}
+
+	want := []string{"3", "5", "7", "12", "20"}
+	if got := affectedTransactions(result); !reflect.DeepEqual(got, want) {
+		t.Errorf("affectedTransactions() = %v, want %v", got, want)
+	}
+}
+
+func TestFilterTransactionIDs(t *testing.T) {
+	ids := []int{1, 2, 3, 4}
+
+	if got := filterTransactionIDs(ids, nil); !reflect.DeepEqual(got, ids) {
+		t.Errorf("filterTransactionIDs() = %v, want all IDs without a scope", got)
+	}
+
+	even := func(id int) bool { return id%2 == 0 }
+	if got := filterTransactionIDs(ids, even); !reflect.DeepEqual(got, []int{2, 4}) {
+		t.Errorf("filterTransactionIDs() = %v, want [2 4]", got)
+	}
+}
+
+func TestRepairTransactions(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/transactions" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			return
+		}
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		received[body["transaction_id"].(string)] = r.Method
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	source := &stubHistorySource{}
+	for _, id := range []string{"1", "2", "3", "4"} {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: id})
+	}
+	result := &VerificationResult{
+		MissingOnServer:  []string{"1"},
+		WithMissingItems: []string{"2"},
+		WithExtraItems:   []string{"4"},
+	}
+
+	failures, err := repairTransactions(source, "machine", "host", result, 0)
+	if err != nil {
+		t.Fatalf("repairTransactions() error = %v", err)
+	}
+	if len(failures) != 0 {
+		t.Errorf("failures = %v, want none", failures)
+	}
+
+	want := map[string]string{"1": http.MethodPost, "2": http.MethodPut, "4": http.MethodPut}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("server received %v, want %v", received, want)
+	}
+}
+
+func TestRepairTransactions_CannotReplace(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.Method)
+		mu.Unlock()
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	source := &stubHistorySource{entries: []HistoryEntry{{TransactionID: "1"}, {TransactionID: "2"}}}
+	result := &VerificationResult{
+		MissingOnServer:  []string{"1"},
+		WithMissingItems: []string{"2"},
+	}
+
+	failures, err := repairTransactions(source, "machine", "host", result, 0)
+	if err != nil {
+		t.Fatalf("repairTransactions() error = %v", err)
+	}
+	if len(failures) != 1 || failures[0].TransactionID != "2" || !errors.Is(failures[0].Err, errReplaceUnsupported) {
+		t.Errorf("failures = %v, want transaction 2, which the server cannot replace", failures)
+	}
+	if !reflect.DeepEqual(received, []string{http.MethodPost, http.MethodPut}) {
+		t.Errorf("server received %v, want the missing transaction and a replacement", received)
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -61,6 +62,13 @@ func initConfig() {
 	// If API key is configured, validate server version compatibility
 	if viper.IsSet("server.api_key") && viper.GetString("server.api_key") != "" {
 		if err := ValidateServerVersionForAPIKey(); err != nil {
+			// An unreachable server is not a configuration error, the build
+			// command spools its payloads until the server is back
+			var unavailable *ServerUnavailableError
+			if errors.As(err, &unavailable) {
+				fmt.Fprintln(os.Stderr, "Warning: unable to check API key compatibility:", err.Error())
+				return
+			}
 			fmt.Fprintln(os.Stderr, "API key compatibility error:", err.Error())
 			os.Exit(1)
 		}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ScriptletLine is a line of scriptlet output, attributed to the package whose
+// scriptlet printed it when the output tells so.
+type ScriptletLine struct {
+	Package string `json:"package,omitempty"`
+	Level   string `json:"level,omitempty"`
+	Text    string `json:"text"`
+}
+
+// Levels of the scriptlet output lines that report a problem.
+const (
+	scriptletLevelError   = "error"
+	scriptletLevelWarning = "warning"
+)
+
+// Limits of the scriptlet output sent for a single transaction. A broken
+// scriptlet can print megabytes of output, which the server does not need to
+// tell what went wrong.
+const (
+	maxScriptletLines = 1000
+	maxScriptletBytes = 64 * 1024
+)
+
+var (
+	// rpm names the scriptlet and the package in its own messages, as in
+	// "warning: %post(foo-1.0-1.el9.x86_64) scriptlet failed, exit status 1"
+	reScriptletPackage = regexp.MustCompile(`%(?:pre|post|verify|trigger|filetrigger|transfiletrigger)\w*\(([^)]+)\)`)
+	// DNF announces each scriptlet before running it
+	reRunningScriptlet = regexp.MustCompile(`^\s*Running scriptlet:\s+(\S+)`)
+	reScriptletError   = regexp.MustCompile(`(?i)^\s*(error|fatal)\b`)
+	reScriptletWarning = regexp.MustCompile(`(?i)^\s*warning\b`)
+)
+
+// completeTransactionDetail fills the fields of a transaction that are derived
+// from the ones read from the history source, so that every source reports
+// them the same way: the status, the package changes, and the capped and
+// attributed scriptlet output.
+func completeTransactionDetail(transaction *TransactionDetail) {
+	var message string
+	transaction.Status, transaction.ExitCode, message = parseReturnCode(transaction.ReturnCode)
+	if message == "" && transaction.Status != statusSuccess {
+		message = strings.Join(transaction.Errors, "\n")
+	}
+	transaction.ErrorMessage = message
+
+	transaction.PackageChanges = linkPackageChanges(transaction.PackagesAltered)
+
+	output, omitted := truncateScriptletOutput(transaction.ScriptletOutput)
+	transaction.Scriptlets = parseScriptletOutput(output)
+	if omitted > 0 {
+		marker := fmt.Sprintf("[... %d more lines of scriptlet output truncated]", omitted)
+		output = append(output, marker)
+		transaction.Scriptlets = append(transaction.Scriptlets, ScriptletLine{Text: marker})
+	}
+	transaction.ScriptletOutput = output
+}
+
+// truncateScriptletOutput caps the scriptlet output to maxScriptletLines
+// lines and maxScriptletBytes bytes. It returns the lines that fit, the last
+// one possibly cut, and the number of lines left out.
+func truncateScriptletOutput(lines []string) ([]string, int) {
+	size := 0
+	for i, line := range lines {
+		if i == maxScriptletLines {
+			return lines[:i], len(lines) - i
+		}
+		if size+len(line) > maxScriptletBytes {
+			kept := append([]string{}, lines[:i]...)
+			if remaining := maxScriptletBytes - size; remaining > 0 {
+				kept = append(kept, strings.ToValidUTF8(line[:remaining], ""))
+			}
+			return kept, len(lines) - len(kept)
+		}
+		size += len(line)
+	}
+
+	return lines, 0
+}
+
+// parseScriptletOutput attributes each line of scriptlet output to a package
+// and flags the lines reporting errors and warnings. A line is attributed to
+// the package it names in an rpm scriptlet message or, failing that, to the
+// package of the last "Running scriptlet" line. Lines printed before any of
+// them are not attributed.
+func parseScriptletOutput(lines []string) []ScriptletLine {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	result := make([]ScriptletLine, 0, len(lines))
+	current := ""
+	for _, line := range lines {
+		if matches := reRunningScriptlet.FindStringSubmatch(line); matches != nil {
+			current = matches[1]
+		}
+
+		scriptletLine := ScriptletLine{Package: current, Text: line}
+		if matches := reScriptletPackage.FindStringSubmatch(line); matches != nil {
+			scriptletLine.Package = matches[1]
+		}
+
+		switch {
+		case reScriptletError.MatchString(line):
+			scriptletLine.Level = scriptletLevelError
+		case reScriptletWarning.MatchString(line):
+			scriptletLine.Level = scriptletLevelWarning
+		}
+
+		result = append(result, scriptletLine)
+	}
+
+	return result
+}
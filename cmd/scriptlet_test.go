@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScriptletOutput(t *testing.T) {
+	lines := []string{
+		"Created symlink /etc/systemd/system/multi-user.target.wants/foo.service.",
+		"Running scriptlet: httpd-2.4.57-5.el9.x86_64",
+		"useradd: warning: the home directory already exists.",
+		"warning: %post(httpd-2.4.57-5.el9.x86_64) scriptlet failed, exit status 1",
+		"error: %prein(nginx-1:1.20.1-14.el9.x86_64) scriptlet failed, exit status 2",
+		"Error: Transaction failed",
+	}
+
+	want := []ScriptletLine{
+		{Text: lines[0]},
+		{Package: "httpd-2.4.57-5.el9.x86_64", Text: lines[1]},
+		{Package: "httpd-2.4.57-5.el9.x86_64", Text: lines[2]},
+		{Package: "httpd-2.4.57-5.el9.x86_64", Level: "warning", Text: lines[3]},
+		{Package: "nginx-1:1.20.1-14.el9.x86_64", Level: "error", Text: lines[4]},
+		{Package: "httpd-2.4.57-5.el9.x86_64", Level: "error", Text: lines[5]},
+	}
+
+	if got := parseScriptletOutput(lines); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseScriptletOutput() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTruncateScriptletOutput(t *testing.T) {
+	t.Run("within limits", func(t *testing.T) {
+		lines := []string{"one", "two"}
+		got, omitted := truncateScriptletOutput(lines)
+		if !reflect.DeepEqual(got, lines) || omitted != 0 {
+			t.Errorf("truncateScriptletOutput() = %v, %d, want %v, 0", got, omitted, lines)
+		}
+	})
+
+	t.Run("too many lines", func(t *testing.T) {
+		lines := make([]string, maxScriptletLines+5)
+		got, omitted := truncateScriptletOutput(lines)
+		if len(got) != maxScriptletLines || omitted != 5 {
+			t.Errorf("truncateScriptletOutput() kept %d lines and omitted %d, want %d and 5", len(got), omitted, maxScriptletLines)
+		}
+	})
+
+	t.Run("too many bytes", func(t *testing.T) {
+		lines := []string{"first", strings.Repeat("x", maxScriptletBytes), "last"}
+		got, omitted := truncateScriptletOutput(lines)
+		if len(got) != 2 || omitted != 1 {
+			t.Fatalf("truncateScriptletOutput() kept %d lines and omitted %d, want 2 and 1", len(got), omitted)
+		}
+		if size := len(got[0]) + len(got[1]); size != maxScriptletBytes {
+			t.Errorf("kept %d bytes, want %d", size, maxScriptletBytes)
+		}
+	})
+}
+
+func TestCompleteTransactionDetail_Truncated(t *testing.T) {
+	transaction := TransactionDetail{ScriptletOutput: make([]string, maxScriptletLines+1)}
+	completeTransactionDetail(&transaction)
+
+	if len(transaction.ScriptletOutput) != maxScriptletLines+1 {
+		t.Fatalf("ScriptletOutput has %d lines, want %d", len(transaction.ScriptletOutput), maxScriptletLines+1)
+	}
+	marker := transaction.ScriptletOutput[maxScriptletLines]
+	if !strings.Contains(marker, "1 more lines of scriptlet output truncated") {
+		t.Errorf("last line = %q, want a truncation marker", marker)
+	}
+	if last := transaction.Scriptlets[len(transaction.Scriptlets)-1]; last.Text != marker {
+		t.Errorf("last scriptlet line = %q, want %q", last.Text, marker)
+	}
+}
@@ -0,0 +1,335 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+	"github.com/txlog/agent/internal/spool"
+	"github.com/txlog/agent/util"
+)
+
+// defaultSpoolDir is used when the agent.spool_dir setting is not set.
+const defaultSpoolDir = "/var/spool/txlog"
+
+// lastSavedFile is the file of the spool directory holding the highest
+// transaction ID the server was known to have, so that only newer
+// transactions are spooled when the server cannot be reached at all.
+const lastSavedFile = "last_saved_id"
+
+// spoolDir returns the spool directory set by agent.spool_dir. An empty
+// setting disables the spool.
+func spoolDir() string {
+	if viper.IsSet("agent.spool_dir") {
+		return viper.GetString("agent.spool_dir")
+	}
+	return defaultSpoolDir
+}
+
+// openSpool opens the spool directory. The spool is optional: when it is
+// disabled or cannot be opened, a warning is printed and nil is returned.
+func openSpool() *spool.Spool {
+	dir := spoolDir()
+	if dir == "" {
+		return nil
+	}
+
+	sp, err := spool.Open(dir)
+	if err != nil {
+		color.Yellow("⚠ Warning: spool disabled: %v", err)
+		return nil
+	}
+
+	return sp
+}
+
+// spoolPayload stores a payload that could not be delivered to the server in
+// the spool, to be replayed by the next build.
+func spoolPayload(sp *spool.Spool, kind, key string, body interface{}) error {
+	if sp == nil {
+		return fmt.Errorf("spool is disabled")
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	return sp.Add(kind, key, data)
+}
+
+// serverUnavailable reports whether a request failed because the server could
+// not be reached, was temporarily unavailable or asked to slow down, as opposed
+// to a request the server rejected. Only the former are worth spooling for a
+// later retry.
+func serverUnavailable(response *resty.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch response.StatusCode() {
+	case 429, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+// serverRejected reports whether the server refused a payload because of its
+// content, so that sending it again cannot succeed. Other errors, such as an
+// authentication failure, are not tied to the payload and keep it spooled.
+func serverRejected(statusCode int) bool {
+	switch statusCode {
+	case 400, 409, 413, 422:
+		return true
+	}
+	return false
+}
+
+// spoolEmpty reports whether no payload is waiting in the spool, which is the
+// case of a disabled spool.
+func spoolEmpty(sp *spool.Spool) bool {
+	if sp == nil {
+		return true
+	}
+	stats, err := sp.Stats()
+	return err == nil && stats.Count == 0
+}
+
+// replaySpool delivers the payloads left in the spool by previous builds, in
+// the order they were spooled. Transactions the server already has are
+// dropped without being sent again, and each delivered transaction is added
+// to savedSet. Payloads the server rejects as invalid (see serverRejected)
+// are moved to the rejected subdirectory of the spool for inspection, so that
+// they are not sent again on every run; replay stops at the first other
+// failure, such as an unavailable server or an authentication error, and
+// keeps the remaining payloads spooled. Transactions larger than maxBodySize bytes are
+// sent in chunks, see uploadMaxBodySize.
+//
+// Returns:
+//   - int: number of payloads delivered
+//   - int: number of payloads dropped as duplicates
+//   - error: the error that stopped the replay, if any
+func replaySpool(sp *spool.Spool, savedSet map[string]struct{}, maxBodySize int) (int, int, error) {
+	entries, err := sp.Entries()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	client := util.NewServerClient()
+
+	delivered, dropped := 0, 0
+	for _, entry := range entries {
+		var endpoint string
+		switch entry.Kind {
+		case spool.KindTransaction:
+			if _, exists := savedSet[entry.Key]; exists {
+				if err := sp.Remove(entry); err != nil {
+					return delivered, dropped, err
+				}
+				dropped++
+				continue
+			}
+			endpoint = "/v1/transactions"
+		case spool.KindExecution:
+			endpoint = "/v1/executions"
+		default:
+			continue
+		}
+
+		body, err := sp.Read(entry)
+		if err != nil {
+			return delivered, dropped, err
+		}
+
+		if entry.Kind == spool.KindTransaction && maxBodySize > 0 && len(body) > maxBodySize {
+			var payload map[string]interface{}
+			err := json.Unmarshal(body, &payload)
+			if err == nil {
+				err = sendTransaction(client, payload, maxBodySize)
+			}
+
+			var unavailable *ServerUnavailableError
+			var status *ServerStatusError
+			if errors.As(err, &unavailable) || errors.As(err, &status) && !serverRejected(status.StatusCode) {
+				return delivered, dropped, err
+			}
+			if err != nil {
+				if err := rejectSpooled(sp, entry, err); err != nil {
+					return delivered, dropped, err
+				}
+				continue
+			}
+		} else {
+			request := client.R().
+				SetHeader("Content-Type", "application/json").
+				SetBody(body)
+
+			util.SetAuthentication(request)
+			setIdempotencyKey(request, body)
+
+			response, err := request.Post(viper.GetString("server.url") + endpoint)
+
+			if serverUnavailable(response, err) {
+				if err == nil {
+					err = fmt.Errorf("server returned status code %d", response.StatusCode())
+				}
+				return delivered, dropped, err
+			}
+
+			if response.StatusCode() != 200 {
+				err := &ServerStatusError{StatusCode: response.StatusCode()}
+				if !serverRejected(err.StatusCode) {
+					return delivered, dropped, err
+				}
+				if err := rejectSpooled(sp, entry, err); err != nil {
+					return delivered, dropped, err
+				}
+				continue
+			}
+		}
+
+		if err := sp.Remove(entry); err != nil {
+			return delivered, dropped, err
+		}
+		if entry.Kind == spool.KindTransaction {
+			savedSet[entry.Key] = struct{}{}
+		}
+		delivered++
+	}
+
+	return delivered, dropped, nil
+}
+
+// rejectSpooled moves a spooled payload the server rejected out of the replay
+// queue, and logs why.
+func rejectSpooled(sp *spool.Spool, entry spool.Entry, reason error) error {
+	if err := sp.Reject(entry); err != nil {
+		return err
+	}
+	color.Red("   ✗ Spooled %s %s rejected (%v), moved to %s", entry.Kind, entry.Key, reason, filepath.Join(sp.Dir(), spool.RejectedDir))
+	return nil
+}
+
+// readLastSavedID returns the highest transaction ID the server was known to
+// have, or 0 if unknown.
+func readLastSavedID(sp *spool.Spool) int {
+	data, err := os.ReadFile(filepath.Join(sp.Dir(), lastSavedFile))
+	if err != nil {
+		return 0
+	}
+
+	id, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return id
+}
+
+// writeLastSavedID records the highest transaction ID the server has.
+func writeLastSavedID(sp *spool.Spool, savedTransactions []int) error {
+	last := 0
+	for _, id := range savedTransactions {
+		last = max(last, id)
+	}
+
+	return os.WriteFile(filepath.Join(sp.Dir(), lastSavedFile), []byte(strconv.Itoa(last)+"\n"), 0o600)
+}
+
+// spoolUnsentTransactions stores in the spool the local transactions newer
+// than the last one the server was known to have. It is used when the server
+// cannot be reached at all, so that the transactions are kept even if the
+// local history is lost before the server is back.
+//
+// Returns:
+//   - int: number of transactions spooled
+//   - error: any error encountered during execution
+func spoolUnsentTransactions(sp *spool.Spool, source HistorySource, machineId, hostname string) (int, error) {
+	entries, err := source.Entries()
+	if err != nil {
+		return 0, err
+	}
+
+	lastSaved := readLastSavedID(sp)
+	unsent := make([]string, 0, len(entries))
+	entriesByID := make(map[string]HistoryEntry, len(entries))
+	for _, entry := range entries {
+		if id, err := strconv.Atoi(entry.TransactionID); err == nil && id > lastSaved {
+			unsent = append(unsent, entry.TransactionID)
+			entriesByID[entry.TransactionID] = entry
+		}
+	}
+
+	spooled := 0
+	err = eachTransaction(source, unsent, func(transactionID string, details TransactionDetail, err error) error {
+		if err != nil {
+			return err
+		}
+
+		body := transactionPayload(entriesByID[transactionID], details, machineId, hostname)
+		if err := spoolPayload(sp, spool.KindTransaction, transactionID, body); err != nil {
+			return err
+		}
+
+		spooled++
+		color.Yellow("   ⏸ Transaction #%s spooled", transactionID)
+		return nil
+	})
+
+	return spooled, err
+}
+
+// spoolStats returns the statistics of the spool without creating its
+// directory. A disabled or missing spool is empty.
+func spoolStats() (spool.Stats, error) {
+	dir := spoolDir()
+	if dir == "" {
+		return spool.Stats{}, nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return spool.Stats{}, nil
+	}
+
+	sp, err := spool.Open(dir)
+	if err != nil {
+		return spool.Stats{}, err
+	}
+	return sp.Stats()
+}
+
+// printSpoolStatus prints the number, size and age of the payloads waiting in
+// the spool. It does not create the spool directory.
+func printSpoolStatus() {
+	if spoolDir() == "" {
+		fmt.Printf("📮 Spool:        %s\n", color.YellowString("disabled"))
+		return
+	}
+
+	stats, err := spoolStats()
+	if err != nil {
+		fmt.Printf("📮 Spool:        %s\n", color.RedString("%v", err))
+		return
+	}
+	if stats.Count == 0 {
+		fmt.Printf("📮 Spool:        %s\n", color.GreenString("empty"))
+		return
+	}
+
+	age := time.Since(stats.Oldest).Truncate(time.Second)
+	fmt.Printf("📮 Spool:        %s\n", color.YellowString("%d payloads, %s, oldest %s ago", stats.Count, formatBytes(stats.Size), age))
+}
+
+// formatBytes returns a size in a human readable form.
+func formatBytes(size int64) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1f MiB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KiB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
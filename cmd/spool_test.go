@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+	"github.com/txlog/agent/internal/spool"
+)
+
+func TestReplaySpool(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r.URL.Path+" "+string(body))
+		if string(body) == `{"transaction_id":"4"}` {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	sp, err := spool.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sp.Add(spool.KindTransaction, "2", []byte(`{"transaction_id":"2"}`))
+	sp.Add(spool.KindTransaction, "3", []byte(`{"transaction_id":"3"}`))
+	sp.Add(spool.KindTransaction, "4", []byte(`{"transaction_id":"4"}`))
+	sp.Add(spool.KindExecution, "1", []byte(`{"success":false}`))
+
+	savedSet := map[string]struct{}{"2": {}}
+	delivered, dropped, err := replaySpool(sp, savedSet, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if delivered != 2 || dropped != 1 {
+		t.Errorf("expected 2 delivered and 1 dropped, got %d and %d", delivered, dropped)
+	}
+
+	expected := []string{
+		`/v1/transactions {"transaction_id":"3"}`,
+		`/v1/transactions {"transaction_id":"4"}`,
+		`/v1/executions {"success":false}`,
+	}
+	if len(received) != len(expected) {
+		t.Fatalf("expected %d requests, got %d: %v", len(expected), len(received), received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Errorf("request %d: expected %s, got %s", i, expected[i], received[i])
+		}
+	}
+
+	if _, ok := savedSet["3"]; !ok {
+		t.Errorf("expected delivered transaction 3 in the saved set")
+	}
+
+	// The rejected transaction is moved out of the spool, so that it is not
+	// sent again by the next replay
+	if !spoolEmpty(sp) {
+		entries, _ := sp.Entries()
+		t.Errorf("expected an empty spool, got %+v", entries)
+	}
+	if _, err := os.Stat(filepath.Join(sp.Dir(), spool.RejectedDir)); err != nil {
+		t.Errorf("expected transaction 4 in the rejected directory: %v", err)
+	}
+
+	received = nil
+	if _, _, err := replaySpool(sp, savedSet, 0); err != nil || len(received) != 0 {
+		t.Errorf("expected nothing replayed, got %v (%v)", received, err)
+	}
+}
+
+func TestServerUnavailable(t *testing.T) {
+	for status, want := range map[int]bool{200: false, 400: false, 409: false, 429: true, 500: false, 502: true, 503: true, 504: true} {
+		response := &resty.Response{RawResponse: &http.Response{StatusCode: status}}
+		if got := serverUnavailable(response, nil); got != want {
+			t.Errorf("serverUnavailable(%d) = %v, want %v", status, got, want)
+		}
+	}
+	if !serverUnavailable(nil, errTest) {
+		t.Error("serverUnavailable() should report a request that failed as unavailable")
+	}
+}
+
+func TestReplaySpool_ServerUnavailable(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	viper.Set("server.retry.max_attempts", 1)
+	defer viper.Reset()
+
+	sp, err := spool.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sp.Add(spool.KindTransaction, "1", []byte(`{"transaction_id":"1"}`))
+	sp.Add(spool.KindTransaction, "2", []byte(`{"transaction_id":"2"}`))
+
+	delivered, _, err := replaySpool(sp, map[string]struct{}{}, 0)
+	if err == nil {
+		t.Fatal("expected error when the server is unavailable")
+	}
+	if delivered != 0 || requests != 1 {
+		t.Errorf("expected replay to stop at the first request, got %d delivered in %d requests", delivered, requests)
+	}
+
+	stats, _ := sp.Stats()
+	if stats.Count != 2 {
+		t.Errorf("expected both payloads kept in the spool, got %d", stats.Count)
+	}
+}
+
+func TestReplaySpool_Unauthorized(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	sp, err := spool.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sp.Add(spool.KindTransaction, "1", []byte(`{"transaction_id":"1"}`))
+	sp.Add(spool.KindTransaction, "2", []byte(`{"transaction_id":"2"}`))
+
+	delivered, _, err := replaySpool(sp, map[string]struct{}{}, 0)
+	if err == nil {
+		t.Fatal("expected error when the server rejects the credentials")
+	}
+	if delivered != 0 || requests != 1 {
+		t.Errorf("expected replay to stop at the first request, got %d delivered in %d requests", delivered, requests)
+	}
+
+	// A payload is not rejected because of the credentials used to send it
+	stats, _ := sp.Stats()
+	if stats.Count != 2 {
+		t.Errorf("expected both payloads kept in the spool, got %d", stats.Count)
+	}
+	if _, err := os.Stat(filepath.Join(sp.Dir(), spool.RejectedDir)); !os.IsNotExist(err) {
+		t.Errorf("expected no rejected directory, got %v", err)
+	}
+}
+
+func TestLastSavedID(t *testing.T) {
+	sp, err := spool.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if id := readLastSavedID(sp); id != 0 {
+		t.Errorf("expected 0 when unknown, got %d", id)
+	}
+
+	if err := writeLastSavedID(sp, []int{3, 12, 7}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id := readLastSavedID(sp); id != 12 {
+		t.Errorf("expected 12, got %d", id)
+	}
+}
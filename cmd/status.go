@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Statuses of a transaction, normalized from the Return-Code printed by dnf
+// and yum.
+const (
+	statusSuccess    = "success"
+	statusFailure    = "failure"
+	statusIncomplete = "incomplete"
+)
+
+// noExitCode is the exit code of transactions that did not finish.
+const noExitCode = -1
+
+// reFailureReturnCode matches a failed Return-Code, as in "Failure: 1" or
+// "Failure: 1 <message>".
+var reFailureReturnCode = regexp.MustCompile(`^Failure:\s*(\d+)?\s*(.*)$`)
+
+// parseReturnCode normalizes the Return-Code of a transaction into a status,
+// its numeric exit code and the error message printed along with it, if any.
+// Transactions that are still running or were interrupted ("** Aborted **")
+// are incomplete and have no exit code.
+func parseReturnCode(returnCode string) (string, int, string) {
+	returnCode = strings.TrimSpace(returnCode)
+
+	switch {
+	case returnCode == "Success":
+		return statusSuccess, 0, ""
+	case returnCode == "" || strings.Contains(returnCode, "Aborted"):
+		return statusIncomplete, noExitCode, ""
+	}
+
+	if matches := reFailureReturnCode.FindStringSubmatch(returnCode); matches != nil {
+		code := 1
+		if matches[1] != "" {
+			code, _ = strconv.Atoi(matches[1])
+		}
+		return statusFailure, code, strings.TrimSpace(matches[2])
+	}
+
+	return statusFailure, noExitCode, returnCode
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import "testing"
+
+func TestParseReturnCode(t *testing.T) {
+	tests := []struct {
+		returnCode  string
+		wantStatus  string
+		wantCode    int
+		wantMessage string
+	}{
+		{"Success", "success", 0, ""},
+		{"Failure: 1", "failure", 1, ""},
+		{"Failure: 127", "failure", 127, ""},
+		{"Failure: 1 Transaction check error", "failure", 1, "Transaction check error"},
+		{"Failure:", "failure", 1, ""},
+		{"** Aborted **", "incomplete", -1, ""},
+		{"", "incomplete", -1, ""},
+		{"Unexpected", "failure", -1, "Unexpected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.returnCode, func(t *testing.T) {
+			status, code, message := parseReturnCode(tt.returnCode)
+			if status != tt.wantStatus || code != tt.wantCode || message != tt.wantMessage {
+				t.Errorf("parseReturnCode(%q) = %q, %d, %q, want %q, %d, %q",
+					tt.returnCode, status, code, message, tt.wantStatus, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
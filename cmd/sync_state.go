@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// defaultStateFile is used when the agent.state_file setting is not set.
+const defaultStateFile = "/var/lib/txlog/state.json"
+
+// syncState records the local history as of the last build that left the
+// server fully in sync, so that a build finding the same history can skip
+// asking the server what it has. The state only applies to the server and
+// machine it was recorded for.
+type syncState struct {
+	ServerURL         string    `json:"server_url"`
+	MachineID         string    `json:"machine_id"`
+	LastTransactionID string    `json:"last_transaction_id"`
+	RPMDBChecksum     string    `json:"rpmdb_checksum"`
+	SyncedAt          time.Time `json:"synced_at"`
+}
+
+// stateFile returns the sync-state file set by agent.state_file. An empty
+// setting disables the sync state.
+func stateFile() string {
+	if viper.IsSet("agent.state_file") {
+		return viper.GetString("agent.state_file")
+	}
+	return defaultStateFile
+}
+
+// currentSyncState describes the local history of source as a syncState for
+// the configured server and machineId. The latest transaction is identified by
+// its ID and the rpmdb checksum it ended with, so that a history recreated
+// from scratch is not mistaken for the one that was synced.
+func currentSyncState(source HistorySource, machineId string) (syncState, error) {
+	state := syncState{
+		ServerURL: viper.GetString("server.url"),
+		MachineID: machineId,
+	}
+
+	transactionID, err := latestTransactionID(source)
+	if err != nil || transactionID == "" {
+		return state, err
+	}
+
+	transaction, err := source.Transaction(transactionID)
+	if err != nil {
+		return state, err
+	}
+
+	state.LastTransactionID = transactionID
+	state.RPMDBChecksum = transaction.EndRPMDB
+	return state, nil
+}
+
+// matches reports whether two states describe the same history, synced to the
+// same server from the same machine.
+func (s syncState) matches(other syncState) bool {
+	return s.ServerURL == other.ServerURL &&
+		s.MachineID == other.MachineID &&
+		s.LastTransactionID == other.LastTransactionID &&
+		s.RPMDBChecksum == other.RPMDBChecksum
+}
+
+// loadSyncState reads the sync state saved at path. A missing file is not an
+// error and returns a zero state.
+func loadSyncState(path string) (syncState, error) {
+	var state syncState
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// saveSyncState writes state to path, through a temporary file so that a
+// crash never leaves a truncated state behind.
+func saveSyncState(path string, state syncState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// rpmdbHistorySource reports the same end rpmdb checksum for every transaction.
+type rpmdbHistorySource struct {
+	stubHistorySource
+	rpmdb string
+}
+
+func (s *rpmdbHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	return TransactionDetail{TransactionID: transactionID, EndRPMDB: s.rpmdb}, nil
+}
+
+func TestCurrentSyncState(t *testing.T) {
+	viper.Reset()
+	viper.Set("server.url", "https://txlog.example.com")
+	defer viper.Reset()
+
+	source := &rpmdbHistorySource{rpmdb: "1234:abcd"}
+	source.entries = []HistoryEntry{{TransactionID: "1"}, {TransactionID: "2"}}
+
+	state, err := currentSyncState(source, "machine")
+	if err != nil {
+		t.Fatalf("currentSyncState() error = %v", err)
+	}
+
+	want := syncState{
+		ServerURL:         "https://txlog.example.com",
+		MachineID:         "machine",
+		LastTransactionID: "2",
+		RPMDBChecksum:     "1234:abcd",
+	}
+	if state != want {
+		t.Errorf("currentSyncState() = %+v, want %+v", state, want)
+	}
+
+	empty, err := currentSyncState(&stubHistorySource{}, "machine")
+	if err != nil {
+		t.Fatalf("currentSyncState() error = %v", err)
+	}
+	if empty.LastTransactionID != "" || empty.RPMDBChecksum != "" {
+		t.Errorf("currentSyncState() = %+v for an empty history", empty)
+	}
+}
+
+func TestSyncStateMatches(t *testing.T) {
+	state := syncState{
+		ServerURL:         "https://txlog.example.com",
+		MachineID:         "machine",
+		LastTransactionID: "2",
+		RPMDBChecksum:     "1234:abcd",
+		SyncedAt:          time.Now(),
+	}
+
+	same := state
+	same.SyncedAt = time.Time{}
+	if !state.matches(same) {
+		t.Error("states differing only by sync time should match")
+	}
+
+	for name, change := range map[string]func(*syncState){
+		"server":      func(s *syncState) { s.ServerURL = "https://other.example.com" },
+		"machine":     func(s *syncState) { s.MachineID = "other" },
+		"transaction": func(s *syncState) { s.LastTransactionID = "3" },
+		"rpmdb":       func(s *syncState) { s.RPMDBChecksum = "1234:ef01" },
+	} {
+		other := state
+		change(&other)
+		if state.matches(other) {
+			t.Errorf("states with another %s should not match", name)
+		}
+	}
+}
+
+func TestSaveAndLoadSyncState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lib", "state.json")
+
+	state, err := loadSyncState(path)
+	if err != nil {
+		t.Fatalf("loadSyncState() error = %v for a missing file", err)
+	}
+	if state != (syncState{}) {
+		t.Errorf("loadSyncState() = %+v for a missing file, want a zero state", state)
+	}
+
+	saved := syncState{
+		ServerURL:         "https://txlog.example.com",
+		MachineID:         "machine",
+		LastTransactionID: "2",
+		RPMDBChecksum:     "1234:abcd",
+		SyncedAt:          time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := saveSyncState(path, saved); err != nil {
+		t.Fatalf("saveSyncState() error = %v", err)
+	}
+
+	loaded, err := loadSyncState(path)
+	if err != nil {
+		t.Fatalf("loadSyncState() error = %v", err)
+	}
+	if !loaded.matches(saved) || !loaded.SyncedAt.Equal(saved.SyncedAt) {
+		t.Errorf("loadSyncState() = %+v, want %+v", loaded, saved)
+	}
+}
@@ -1,24 +1,22 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/go-resty/resty/v2"
+	"github.com/itlightning/dateparse"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/txlog/agent/util"
 )
 
-// Pre-compiled regex for parsing local DNF history output
-var reLocalTransactionID = regexp.MustCompile(`^\s*(\d+)\s*\|`)
-
 // ServerTransaction represents a transaction as stored on the server
 type ServerTransaction struct {
 	TransactionID   string    `json:"transaction_id"`
@@ -38,12 +36,50 @@ type ServerTransaction struct {
 
 // VerificationResult holds the results of the verification process
 type VerificationResult struct {
-	TotalLocalTransactions  int
-	TotalServerTransactions int
-	MissingOnServer         []string
-	WithMissingItems        []string
-	WithExtraItems          []string
-	FullyVerified           int
+	MachineID               string                    `json:"machine_id"`
+	Hostname                string                    `json:"hostname"`
+	TotalLocalTransactions  int                       `json:"total_local_transactions"`
+	TotalServerTransactions int                       `json:"total_server_transactions"`
+	MissingOnServer         []string                  `json:"missing_on_server"`
+	WithMissingItems        []string                  `json:"with_missing_items"`
+	WithExtraItems          []string                  `json:"with_extra_items"`
+	WithFieldMismatches     []string                  `json:"with_field_mismatches"`
+	FullyVerified           int                       `json:"fully_verified"`
+	Transactions            []TransactionVerification `json:"transactions"`
+}
+
+// Verification statuses of a transaction
+const (
+	verificationVerified        = "verified"
+	verificationMissingOnServer = "missing_on_server"
+	verificationItemsDiffer     = "items_differ"
+	verificationFieldsDiffer    = "fields_differ"
+	verificationUnverified      = "unverified"
+)
+
+// TransactionVerification holds the result of the verification of a local
+// transaction: the packages missing or extra on the server and the fields
+// that differ, or the error that prevented its verification.
+type TransactionVerification struct {
+	TransactionID   string          `json:"transaction_id"`
+	Status          string          `json:"status"`
+	MissingItems    []Package       `json:"missing_items,omitempty"`
+	ExtraItems      []Package       `json:"extra_items,omitempty"`
+	FieldMismatches []FieldMismatch `json:"field_mismatches,omitempty"`
+	Error           string          `json:"error,omitempty"`
+}
+
+// FieldMismatch is a transaction field whose value on the server differs
+// from the local one.
+type FieldMismatch struct {
+	Field  string `json:"field"`
+	Local  string `json:"local"`
+	Server string `json:"server"`
+}
+
+// verified reports whether a verification found no issue.
+func verified(result *VerificationResult) bool {
+	return len(result.MissingOnServer) == 0 && len(result.WithMissingItems) == 0 && len(result.WithExtraItems) == 0 && len(result.WithFieldMismatches) == 0
 }
 
 var verifyCmd = &cobra.Command{
@@ -53,8 +89,52 @@ var verifyCmd = &cobra.Command{
 This command verifies that all local DNF transaction data has been properly
 replicated to the server. It checks:
   - Transactions that exist locally but not on the server
-  - Transaction items (packages) that may be missing or different on the server`,
+  - Transaction items (packages) that may be missing or different on the server
+
+With --digest, the agent compares a hash of each transaction with the one
+computed by the server, and only compares in depth the transactions whose hash
+differs. Servers that do not support digests are compared in depth.
+
+With --from-id and --to-id, --since and --until (on the begin time), --last N
+and --sample N, only the selected transactions are verified, for instance to
+verify the changes of the last day every night:
+
+  txlog verify --since 24h
+
+With --concurrency, several transactions are compared with the server at the
+same time, and --rate caps the requests per second sent to it. Results are
+still printed and reported in transaction order.
+
+With --output json, junit or sarif, the full result is written to stdout in
+that format, and the progress is printed to stderr.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		if !slices.Contains(verifyOutputFormats, output) {
+			color.Red("Error: invalid --output %q, must be one of: %s", output, strings.Join(verifyOutputFormats, ", "))
+			os.Exit(1)
+		}
+		if output != "text" {
+			// * keeps stdout for the report
+			color.Output = os.Stderr
+		}
+
+		workers, err := concurrency(cmd)
+		if err != nil {
+			color.Red("Error: %v", err)
+			os.Exit(1)
+		}
+		rate, _ := cmd.Flags().GetFloat64("rate")
+		if rate < 0 {
+			color.Red("Error: invalid --rate %v, must be 0 or more requests per second", rate)
+			os.Exit(1)
+		}
+
+		scope, err := scopeFromFlags(cmd, time.Now())
+		if err != nil {
+			color.Red("Error: %v", err)
+			os.Exit(1)
+		}
+
 		machineId, err := util.GetMachineId()
 		if err != nil {
 			color.Red("Error getting machine ID: %v", err)
@@ -67,53 +147,129 @@ replicated to the server. It checks:
 			os.Exit(1)
 		}
 
-		fmt.Fprintf(os.Stdout, "Verifying data integrity for %s\n", color.CyanString(hostname))
-		fmt.Fprintf(os.Stdout, "Machine ID: %s\n\n", color.CyanString(machineId))
+		fmt.Fprintf(color.Output, "Verifying data integrity for %s\n", color.CyanString(hostname))
+		fmt.Fprintf(color.Output, "Machine ID: %s\n\n", color.CyanString(machineId))
 
-		result, err := verifyDataIntegrity(machineId, hostname)
+		source, err := openHistorySource(historySourceSpec(cmd))
+		if err != nil {
+			color.Red("Error opening transaction history: %v", err)
+			os.Exit(1)
+		}
+		defer source.Close()
+
+		digest, _ := cmd.Flags().GetBool("digest")
+		result, err := verifyTransactions(source, machineId, hostname, verifyOptions{digest: digest, scope: scope, concurrency: workers, rate: rate})
 		if err != nil {
 			color.Red("Error during verification: %v", err)
 			os.Exit(1)
 		}
 
-		printVerificationResults(result)
+		if output == "text" {
+			printVerificationResults(result)
+		} else if err := writeVerificationReport(os.Stdout, output, result); err != nil {
+			color.Red("Error writing verification report: %v", err)
+			os.Exit(1)
+		}
 
 		// Exit with error code if there are any issues
-		if len(result.MissingOnServer) > 0 || len(result.WithMissingItems) > 0 || len(result.WithExtraItems) > 0 {
+		if !verified(result) {
 			os.Exit(1)
 		}
 	},
 }
 
 func init() {
+	addHistorySourceFlag(verifyCmd)
+	verifyCmd.Flags().String("output", "text", "output format of the result: "+strings.Join(verifyOutputFormats, ", "))
+	addScopeFlags(verifyCmd)
+	addConcurrencyFlag(verifyCmd, "number of transactions compared with the server at the same time")
+	verifyCmd.Flags().Float64("rate", 0, "maximum number of requests per second sent to the server, 0 for no limit")
+	verifyCmd.Flags().Bool("digest", false, "compare transaction digests with the server first, and only compare in depth the transactions that differ")
 	rootCmd.AddCommand(verifyCmd)
 }
 
+// verifyOptions select the transactions verifyTransactions checks, and how.
+type verifyOptions struct {
+	// inScope returns true for the transactions to verify, or is nil to
+	// verify all of them. Transactions out of scope are ignored on both sides.
+	inScope func(transactionID int) bool
+	// digest compares transaction digests first, and only compares in depth
+	// the transactions whose digest differs, see compareDigests
+	digest bool
+	// concurrency is the number of transactions compared in depth at the
+	// same time. Results are recorded in transaction order whatever its value.
+	concurrency int
+	// scope selects the local transactions to verify, on top of inScope.
+	// Only the server transactions selected locally are then looked up.
+	scope verifyScope
+	// rate is the maximum number of requests per second sent to the server,
+	// or 0 for no limit
+	rate float64
+}
+
 // verifyDataIntegrity performs the complete data integrity verification
-func verifyDataIntegrity(machineId, hostname string) (*VerificationResult, error) {
+func verifyDataIntegrity(source HistorySource, machineId, hostname string) (*VerificationResult, error) {
+	return verifyTransactions(source, machineId, hostname, verifyOptions{})
+}
+
+// verifyTransactions performs the data integrity verification of the
+// transactions selected by options.
+func verifyTransactions(source HistorySource, machineId, hostname string, options verifyOptions) (*VerificationResult, error) {
 	result := &VerificationResult{
-		MissingOnServer:  make([]string, 0),
-		WithMissingItems: make([]string, 0),
-		WithExtraItems:   make([]string, 0),
+		MachineID:           machineId,
+		Hostname:            hostname,
+		MissingOnServer:     make([]string, 0),
+		WithMissingItems:    make([]string, 0),
+		WithExtraItems:      make([]string, 0),
+		WithFieldMismatches: make([]string, 0),
+		Transactions:        make([]TransactionVerification, 0),
 	}
 
 	// Get local transactions
-	fmt.Fprintf(os.Stdout, "Reading local transaction history...\n")
-	localTransactions, err := getLocalTransactionIDs()
+	fmt.Fprintf(color.Output, "Reading local transaction history...\n")
+	localTransactions, err := getLocalTransactionIDs(source)
 	if err != nil {
 		return nil, fmt.Errorf("error reading local transactions: %w", err)
 	}
+	// Transactions are checked, and reported, in ascending order of ID
+	localTransactions = filterTransactionIDs(localTransactions, options.inScope)
+	slices.Sort(localTransactions)
+	fmt.Fprintf(color.Output, "Found %s local transactions\n", color.YellowString("%d", len(localTransactions)))
+
+	// Transactions read while selecting or comparing digests are not read again
+	history := withKnownTransactions(source, nil)
+
+	scoped := options.inScope != nil
+	if !options.scope.whole() {
+		found := len(localTransactions)
+		var selectedDetails map[string]TransactionDetail
+		localTransactions, selectedDetails, err = selectTransactions(source, localTransactions, options.scope)
+		if err != nil {
+			return nil, fmt.Errorf("error selecting local transactions: %w", err)
+		}
+		history.add(selectedDetails)
+		fmt.Fprintf(color.Output, "Selected %s of %d local transactions\n", color.YellowString("%d", len(localTransactions)), found)
+
+		selected := make(map[int]bool, len(localTransactions))
+		for _, id := range localTransactions {
+			selected[id] = true
+		}
+		inScope := options.inScope
+		options.inScope = func(id int) bool { return selected[id] && (inScope == nil || inScope(id)) }
+		scoped = true
+	}
 	result.TotalLocalTransactions = len(localTransactions)
-	fmt.Fprintf(os.Stdout, "Found %s local transactions\n", color.YellowString("%d", len(localTransactions)))
 
 	// Get server transactions
-	fmt.Fprintf(os.Stdout, "Retrieving server transactions...\n")
+	fmt.Fprintf(color.Output, "Retrieving server transactions...\n")
 	serverTransactionIDs, _, err := getSavedTransactions(machineId, hostname)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving server transactions: %w", err)
 	}
+	serverTransactionIDs = filterTransactionIDs(serverTransactionIDs, options.inScope)
+	slices.Sort(serverTransactionIDs)
 	result.TotalServerTransactions = len(serverTransactionIDs)
-	fmt.Fprintf(os.Stdout, "Found %s transactions on server\n\n", color.YellowString("%d", len(serverTransactionIDs)))
+	fmt.Fprintf(color.Output, "Found %s transactions on server\n\n", color.YellowString("%d", len(serverTransactionIDs)))
 
 	// Convert server IDs to map for quick lookup
 	serverTransactionsMap := make(map[int]struct{}, len(serverTransactionIDs))
@@ -122,10 +278,14 @@ func verifyDataIntegrity(machineId, hostname string) (*VerificationResult, error
 	}
 
 	// Check for missing transactions on server
-	fmt.Fprintf(os.Stdout, "Checking for missing transactions...\n")
+	fmt.Fprintf(color.Output, "Checking for missing transactions...\n")
 	for _, localID := range localTransactions {
 		if _, exists := serverTransactionsMap[localID]; !exists {
 			result.MissingOnServer = append(result.MissingOnServer, fmt.Sprintf("%d", localID))
+			result.Transactions = append(result.Transactions, TransactionVerification{
+				TransactionID: fmt.Sprintf("%d", localID),
+				Status:        verificationMissingOnServer,
+			})
 			color.Red("  ✗ Transaction #%d exists locally but not on server", localID)
 		}
 	}
@@ -133,7 +293,7 @@ func verifyDataIntegrity(machineId, hostname string) (*VerificationResult, error
 	if len(result.MissingOnServer) == 0 {
 		color.Green("  ✓ All local transactions exist on server")
 	}
-	fmt.Fprintln(os.Stdout)
+	fmt.Fprintln(color.Output)
 
 	// Build a set for O(1) lookup of local transaction IDs
 	localTransactionSet := make(map[int]struct{}, len(localTransactions))
@@ -142,87 +302,167 @@ func verifyDataIntegrity(machineId, hostname string) (*VerificationResult, error
 	}
 
 	// Check transaction items for each transaction on server
-	fmt.Fprintf(os.Stdout, "Verifying transaction items integrity...\n")
-	verifyClient := resty.New()
-	verifyClient.SetTimeout(30 * time.Second)
-	intersectionCount := 0
+	fmt.Fprintf(color.Output, "Verifying transaction items integrity...\n")
+	verifyClient := limitRate(util.NewServerClient(), options.rate)
+	commonIDs := make([]string, 0, len(serverTransactionIDs))
 	for _, serverID := range serverTransactionIDs {
 		// Skip verification if transaction doesn't exist locally
-		if _, found := localTransactionSet[serverID]; !found {
-			continue
+		if _, found := localTransactionSet[serverID]; found {
+			commonIDs = append(commonIDs, fmt.Sprintf("%d", serverID))
+		}
+	}
+	intersectionCount := len(commonIDs)
+
+	if options.digest {
+		whole := !scoped && intersectionCount == len(localTransactions) && intersectionCount == len(serverTransactionIDs)
+		differing, differingDetails, err := compareDigests(history, verifyClient, machineId, hostname, commonIDs, whole, result)
+		switch {
+		case errors.Is(err, errDigestUnsupported):
+			color.Yellow("  ⚠ Warning: %v, comparing every transaction in depth", err)
+		case err != nil:
+			return nil, fmt.Errorf("error comparing transaction digests: %w", err)
+		default:
+			commonIDs = differing
+			history.add(differingDetails)
 		}
-		intersectionCount++
+	}
 
-		// Get local transaction details
-		localDetails, err := getTransactionItems(fmt.Sprintf("%d", serverID))
-		if err != nil {
-			color.Yellow("  ⚠ Warning: Could not get local details for transaction #%d: %v", serverID, err)
-			continue
+	reader := newTransactionReader(history, commonIDs)
+	err = runOrdered(options.concurrency, len(commonIDs), func(i int) TransactionVerification {
+		localDetails, err := reader.read(i)
+		return verifyTransaction(verifyClient, machineId, commonIDs[i], localDetails, err)
+	}, func(i int, verification TransactionVerification) error {
+		recordVerification(result, verification)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(result.Transactions, func(a, b TransactionVerification) int {
+		x, _ := strconv.Atoi(a.TransactionID)
+		y, _ := strconv.Atoi(b.TransactionID)
+		return x - y
+	})
+
+	if result.FullyVerified == intersectionCount {
+		color.Green("  ✓ All transaction items and fields verified successfully")
+	}
+	fmt.Fprintln(color.Output)
+
+	return result, nil
+}
+
+// verifyTransaction compares a local transaction, or the error that
+// prevented reading it, with the one saved on the server. It only reports
+// the differences, which recordVerification prints and counts.
+func verifyTransaction(client *resty.Client, machineId, transactionID string, localDetails TransactionDetail, err error) TransactionVerification {
+	if err != nil {
+		return TransactionVerification{
+			TransactionID: transactionID,
+			Status:        verificationUnverified,
+			Error:         fmt.Sprintf("could not get local details: %v", err),
 		}
+	}
 
-		// Get server transaction details using the /v1/items endpoint
-		serverDetails, err := getServerTransactionItems(verifyClient, machineId, fmt.Sprintf("%d", serverID))
-		if err != nil {
-			color.Yellow("  ⚠ Warning: Could not get server details for transaction #%d: %v", serverID, err)
-			continue
+	// Get server transaction details using the /v1/items endpoint
+	serverDetails, err := getServerTransactionItems(client, machineId, transactionID)
+	if err != nil {
+		return TransactionVerification{
+			TransactionID: transactionID,
+			Status:        verificationUnverified,
+			Error:         fmt.Sprintf("could not get server details: %v", err),
 		}
+	}
 
-		// Compare items
-		missing, extra := compareTransactionItems(localDetails.PackagesAltered, serverDetails.Items)
+	// Compare items and fields
+	missing, extra := compareTransactionItems(localDetails.PackagesAltered, serverDetails.Items)
+	mismatches := compareTransactionFields(localDetails, serverDetails)
+	verification := TransactionVerification{
+		TransactionID:   transactionID,
+		Status:          verificationVerified,
+		MissingItems:    missing,
+		ExtraItems:      extra,
+		FieldMismatches: mismatches,
+	}
 
-		if len(missing) > 0 {
-			result.WithMissingItems = append(result.WithMissingItems, fmt.Sprintf("%d", serverID))
-			color.Red("  ✗ Transaction #%d is missing %d package(s) on server", serverID, len(missing))
-			for _, pkg := range missing {
-				fmt.Fprintf(os.Stdout, "    - %s %s-%s.%s (%s)\n", pkg.Action, pkg.Name, pkg.Version, pkg.Arch, pkg.Repo)
-			}
+	switch {
+	case len(missing) > 0 || len(extra) > 0:
+		verification.Status = verificationItemsDiffer
+	case len(mismatches) > 0:
+		verification.Status = verificationFieldsDiffer
+	}
+	return verification
+}
+
+// recordVerification prints the result of verifyTransaction and adds it to
+// result.
+func recordVerification(result *VerificationResult, verification TransactionVerification) {
+	transactionID := verification.TransactionID
+	result.Transactions = append(result.Transactions, verification)
+
+	switch verification.Status {
+	case verificationUnverified:
+		color.Yellow("  ⚠ Warning: Could not verify transaction #%s: %s", transactionID, verification.Error)
+		return
+	case verificationVerified:
+		result.FullyVerified++
+		return
+	}
+
+	if len(verification.MissingItems) > 0 {
+		result.WithMissingItems = append(result.WithMissingItems, transactionID)
+		color.Red("  ✗ Transaction #%s is missing %d package(s) on server", transactionID, len(verification.MissingItems))
+		for _, pkg := range verification.MissingItems {
+			fmt.Fprintf(color.Output, "    - %s %s-%s.%s (%s)\n", pkg.Action, pkg.Name, pkg.Version, pkg.Arch, pkg.Repo)
 		}
+	}
 
-		if len(extra) > 0 {
-			result.WithExtraItems = append(result.WithExtraItems, fmt.Sprintf("%d", serverID))
-			color.Yellow("  ⚠ Transaction #%d has %d extra package(s) on server", serverID, len(extra))
-			for _, pkg := range extra {
-				fmt.Fprintf(os.Stdout, "    + %s %s-%s.%s\n", pkg.Action, pkg.Name, pkg.Version, pkg.Arch)
-			}
+	if len(verification.ExtraItems) > 0 {
+		result.WithExtraItems = append(result.WithExtraItems, transactionID)
+		color.Yellow("  ⚠ Transaction #%s has %d extra package(s) on server", transactionID, len(verification.ExtraItems))
+		for _, pkg := range verification.ExtraItems {
+			fmt.Fprintf(color.Output, "    + %s %s-%s.%s\n", pkg.Action, pkg.Name, pkg.Version, pkg.Arch)
 		}
+	}
 
-		if len(missing) == 0 && len(extra) == 0 {
-			result.FullyVerified++
+	if len(verification.FieldMismatches) > 0 {
+		result.WithFieldMismatches = append(result.WithFieldMismatches, transactionID)
+		color.Red("  ✗ Transaction #%s has %d field(s) different on server", transactionID, len(verification.FieldMismatches))
+		for _, mismatch := range verification.FieldMismatches {
+			fmt.Fprintf(color.Output, "    ~ %s: %q locally, %q on server\n", mismatch.Field, mismatch.Local, mismatch.Server)
 		}
 	}
+}
 
-	if result.FullyVerified == intersectionCount {
-		color.Green("  ✓ All transaction items verified successfully")
+// filterTransactionIDs returns the transaction IDs for which inScope returns
+// true, or all of them when inScope is nil.
+func filterTransactionIDs(transactionIDs []int, inScope func(transactionID int) bool) []int {
+	if inScope == nil {
+		return transactionIDs
 	}
-	fmt.Fprintln(os.Stdout)
 
-	return result, nil
+	filtered := make([]int, 0, len(transactionIDs))
+	for _, id := range transactionIDs {
+		if inScope(id) {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered
 }
 
-// getLocalTransactionIDs retrieves all transaction IDs from local DNF history
-func getLocalTransactionIDs() ([]int, error) {
-	out, err := exec.Command(util.PackageBinary(), "history", "list").Output()
+// getLocalTransactionIDs retrieves all transaction IDs from the local history source
+func getLocalTransactionIDs(source HistorySource) ([]int, error) {
+	entries, err := source.Entries()
 	if err != nil {
 		return nil, err
 	}
 
-	output := string(out)
-	lines := strings.Split(output, "\n")
-	if len(lines) < 3 {
-		return nil, fmt.Errorf("unexpected dnf history output format")
-	}
-
-	// Skip header lines
-	lines = lines[2:]
-
-	transactionIDs := make([]int, 0)
-
-	for _, line := range lines {
-		if matches := reLocalTransactionID.FindStringSubmatch(line); len(matches) > 1 {
-			id, err := strconv.Atoi(strings.TrimSpace(matches[1]))
-			if err == nil {
-				transactionIDs = append(transactionIDs, id)
-			}
+	transactionIDs := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		id, err := strconv.Atoi(entry.TransactionID)
+		if err == nil {
+			transactionIDs = append(transactionIDs, id)
 		}
 	}
 
@@ -254,6 +494,22 @@ func getServerTransactionItems(httpClient *resty.Client, machineId, transactionI
 	return &transaction, nil
 }
 
+// itemRepo returns the repository of an item as compared by verify. The
+// replaced side of an upgrade or downgrade, printed as "@@<repo>" by dnf, is
+// parsed into FromRepo, but was sent by older agents as Repo "@<repo>": both
+// are compared as the latter, so the items they uploaded still match.
+func itemRepo(pkg Package) string {
+	if pkg.Repo == "" && pkg.FromRepo != "" {
+		return "@" + pkg.FromRepo
+	}
+	return pkg.Repo
+}
+
+// itemKey identifies an item in compareTransactionItems.
+func itemKey(pkg Package) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", pkg.Action, pkg.Name, pkg.Version, pkg.Release, pkg.Epoch, pkg.Arch, itemRepo(pkg))
+}
+
 // compareTransactionItems compares local and server package lists
 // Returns: (missing packages, extra packages)
 func compareTransactionItems(localPackages, serverPackages []Package) ([]Package, []Package) {
@@ -263,34 +519,97 @@ func compareTransactionItems(localPackages, serverPackages []Package) ([]Package
 	// Create a map of server packages for quick lookup
 	serverPkgMap := make(map[string]Package)
 	for _, pkg := range serverPackages {
-		key := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", pkg.Action, pkg.Name, pkg.Version, pkg.Release, pkg.Epoch, pkg.Arch, pkg.Repo)
+		key := itemKey(pkg)
 		serverPkgMap[key] = pkg
 	}
 
 	// Create a map of local packages
 	localPkgMap := make(map[string]Package)
 	for _, pkg := range localPackages {
-		key := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s", pkg.Action, pkg.Name, pkg.Version, pkg.Release, pkg.Epoch, pkg.Arch, pkg.Repo)
+		key := itemKey(pkg)
 		localPkgMap[key] = pkg
 	}
 
-	// Check for missing packages (in local but not in server)
-	for key, pkg := range localPkgMap {
+	// Check for missing packages (in local but not in server), in the local
+	// order so that reports are stable
+	for _, pkg := range localPackages {
+		key := itemKey(pkg)
 		if _, exists := serverPkgMap[key]; !exists {
 			missing = append(missing, pkg)
+			serverPkgMap[key] = pkg
 		}
 	}
 
-	// Check for extra packages (in server but not in local)
-	for key, pkg := range serverPkgMap {
+	// Check for extra packages (in server but not in local), in the server order
+	for _, pkg := range serverPackages {
+		key := itemKey(pkg)
 		if _, exists := localPkgMap[key]; !exists {
 			extra = append(extra, pkg)
+			localPkgMap[key] = pkg
 		}
 	}
 
 	return missing, extra
 }
 
+// compareTransactionFields compares the fields of a local transaction with
+// the ones saved on the server, and returns those that differ. Times are
+// compared as instants, so that the same time written in another format or
+// time zone is not a mismatch, while a time shifted by a time zone bug is.
+// Servers that only return the items, without the transaction ID, are not
+// compared.
+func compareTransactionFields(local TransactionDetail, server *ServerTransaction) []FieldMismatch {
+	var mismatches []FieldMismatch
+	if server.TransactionID == "" {
+		return mismatches
+	}
+
+	fields := []struct {
+		name          string
+		local, server string
+		same          func(a, b string) bool
+	}{
+		{"begin_time", local.BeginTime, server.BeginTime, sameTime},
+		{"end_time", local.EndTime, server.EndTime, sameTime},
+		{"user", local.User, server.User, sameText},
+		{"return_code", local.ReturnCode, server.ReturnCode, sameText},
+		{"release_version", local.Releasever, server.ReleaseVersion, sameText},
+		{"command_line", local.CommandLine, server.CommandLine, sameText},
+	}
+
+	for _, field := range fields {
+		if !field.same(field.local, field.server) {
+			mismatches = append(mismatches, FieldMismatch{Field: field.name, Local: field.local, Server: field.server})
+		}
+	}
+	return mismatches
+}
+
+// sameText reports whether two field values are equal, ignoring surrounding
+// whitespace.
+func sameText(a, b string) bool {
+	return strings.TrimSpace(a) == strings.TrimSpace(b)
+}
+
+// sameTime reports whether two times are the same instant, to the second.
+func sameTime(a, b string) bool {
+	return normalizeTime(a) == normalizeTime(b)
+}
+
+// normalizeTime formats a time in UTC as RFC 3339, to the second, so that
+// the same instant is always written the same way. Times without a time zone
+// are taken as local, like DateConversion does. Times that cannot be parsed
+// are returned as text, without surrounding whitespace.
+func normalizeTime(value string) string {
+	value = strings.TrimSpace(value)
+
+	t, err := dateparse.ParseLocal(value)
+	if err != nil {
+		return value
+	}
+	return t.UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
 // printVerificationResults prints a summary of the verification results
 func printVerificationResults(result *VerificationResult) {
 	fmt.Fprintln(os.Stdout, strings.Repeat("=", 60))
@@ -319,16 +638,20 @@ func printVerificationResults(result *VerificationResult) {
 		fmt.Fprintf(os.Stdout, "With extra items:          %s\n", color.GreenString("0"))
 	}
 
+	if len(result.WithFieldMismatches) > 0 {
+		fmt.Fprintf(os.Stdout, "With field mismatches:     %s\n", color.RedString("%d", len(result.WithFieldMismatches)))
+	} else {
+		fmt.Fprintf(os.Stdout, "With field mismatches:     %s\n", color.GreenString("0"))
+	}
+
 	fmt.Fprintln(os.Stdout, strings.Repeat("=", 60))
 
-	if len(result.MissingOnServer) == 0 && len(result.WithMissingItems) == 0 && len(result.WithExtraItems) == 0 {
+	if verified(result) {
 		color.Green("\n✓ Data integrity verified successfully!")
 		fmt.Fprintln(os.Stdout, "All local transactions and items are properly replicated on the server.")
 	} else {
 		color.Red("\n✗ Data integrity issues detected!")
-		fmt.Fprintln(os.Stdout, "To fix these issues:")
-		fmt.Fprintln(os.Stdout, "  1. Remove the hostname data from the server")
-		fmt.Fprintln(os.Stdout, "  2. Run 'txlog build' to synchronize all data again")
+		fmt.Fprintln(os.Stdout, "To fix these issues, run 'txlog repair' to resend the affected transactions.")
 	}
 	fmt.Fprintln(os.Stdout)
 }
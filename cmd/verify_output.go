@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// verifyOutputFormats are the accepted values of 'txlog verify --output'.
+var verifyOutputFormats = []string{"text", "json", "junit", "sarif"}
+
+// writeVerificationReport writes result to w in a machine-readable format:
+// json, junit or sarif.
+func writeVerificationReport(w io.Writer, format string, result *VerificationResult) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case "junit":
+		return writeJUnitReport(w, result)
+	case "sarif":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(sarifReport(result))
+	default:
+		return fmt.Errorf("unknown output format %q, must be one of: %s", format, strings.Join(verifyOutputFormats, ", "))
+	}
+}
+
+// formatPackages lists packages one per line, as printed by verify.
+func formatPackages(prefix string, packages []Package) string {
+	var lines []string
+	for _, pkg := range packages {
+		lines = append(lines, fmt.Sprintf("%s %s %s-%s-%s.%s (%s)", prefix, pkg.Action, pkg.Name, pkg.Version, pkg.Release, pkg.Arch, pkg.Repo))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatFieldMismatches lists field mismatches one per line, as printed by
+// verify.
+func formatFieldMismatches(mismatches []FieldMismatch) string {
+	var lines []string
+	for _, mismatch := range mismatches {
+		lines = append(lines, fmt.Sprintf("~ %s: %q locally, %q on server", mismatch.Field, mismatch.Local, mismatch.Server))
+	}
+	return strings.Join(lines, "\n")
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitFailure `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnitReport writes result as a JUnit XML report, with a test case per
+// local transaction. Transactions with an issue are failures, and the ones
+// that could not be verified are skipped.
+func writeJUnitReport(w io.Writer, result *VerificationResult) error {
+	suite := junitSuite{
+		Name: "txlog verify " + result.Hostname,
+		Properties: []junitProperty{
+			{Name: "machine_id", Value: result.MachineID},
+			{Name: "hostname", Value: result.Hostname},
+			{Name: "total_local_transactions", Value: fmt.Sprintf("%d", result.TotalLocalTransactions)},
+			{Name: "total_server_transactions", Value: fmt.Sprintf("%d", result.TotalServerTransactions)},
+		},
+	}
+
+	for _, transaction := range result.Transactions {
+		testCase := junitCase{
+			Name:      "transaction #" + transaction.TransactionID,
+			ClassName: "txlog.verify." + result.Hostname,
+		}
+
+		switch transaction.Status {
+		case verificationMissingOnServer:
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("Transaction #%s exists locally but not on server", transaction.TransactionID),
+				Type:    transaction.Status,
+			}
+		case verificationItemsDiffer, verificationFieldsDiffer:
+			var text []string
+			if len(transaction.MissingItems) > 0 {
+				text = append(text, formatPackages("-", transaction.MissingItems))
+			}
+			if len(transaction.ExtraItems) > 0 {
+				text = append(text, formatPackages("+", transaction.ExtraItems))
+			}
+			if len(transaction.FieldMismatches) > 0 {
+				text = append(text, formatFieldMismatches(transaction.FieldMismatches))
+			}
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("Transaction #%s has %d missing and %d extra package(s) and %d different field(s) on server", transaction.TransactionID, len(transaction.MissingItems), len(transaction.ExtraItems), len(transaction.FieldMismatches)),
+				Type:    transaction.Status,
+				Text:    strings.Join(text, "\n"),
+			}
+		case verificationUnverified:
+			testCase.Skipped = &junitFailure{Message: transaction.Error}
+		}
+
+		suite.Tests++
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+		if testCase.Skipped != nil {
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	report := junitTestSuites{
+		Name:     "txlog verify",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Suites:   []junitSuite{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// sarifRules describe the issues reported by verify, by SARIF rule ID.
+var sarifRules = []map[string]interface{}{
+	{
+		"id":               "missing-on-server",
+		"shortDescription": map[string]string{"text": "Transaction exists locally but not on the server"},
+		"help":             map[string]string{"text": "Run 'txlog repair' to resend the transaction."},
+	},
+	{
+		"id":               "missing-items",
+		"shortDescription": map[string]string{"text": "Packages of the transaction are missing on the server"},
+		"help":             map[string]string{"text": "Run 'txlog repair' to replace the transaction on the server."},
+	},
+	{
+		"id":               "extra-items",
+		"shortDescription": map[string]string{"text": "The server has packages the local transaction does not have"},
+		"help":             map[string]string{"text": "Run 'txlog repair' to replace the transaction on the server."},
+	},
+	{
+		"id":               "field-mismatch",
+		"shortDescription": map[string]string{"text": "Fields of the transaction differ on the server"},
+		"help":             map[string]string{"text": "Run 'txlog repair' to replace the transaction on the server."},
+	},
+	{
+		"id":               "unverified",
+		"shortDescription": map[string]string{"text": "Transaction could not be verified"},
+	},
+}
+
+// sarifReport returns result as a SARIF 2.1.0 log, with a result per issue
+// found. Transactions are logical locations, as they have no file.
+func sarifReport(result *VerificationResult) map[string]interface{} {
+	results := make([]map[string]interface{}, 0)
+	add := func(ruleID, level, transactionID, message string) {
+		results = append(results, map[string]interface{}{
+			"ruleId":  ruleID,
+			"level":   level,
+			"message": map[string]string{"text": message},
+			"locations": []map[string]interface{}{{
+				"logicalLocations": []map[string]string{{
+					"name":               "transaction #" + transactionID,
+					"fullyQualifiedName": result.Hostname + "/transactions/" + transactionID,
+					"kind":               "object",
+				}},
+			}},
+		})
+	}
+
+	for _, transaction := range result.Transactions {
+		id := transaction.TransactionID
+		switch transaction.Status {
+		case verificationMissingOnServer:
+			add("missing-on-server", "error", id, fmt.Sprintf("Transaction #%s exists locally but not on server", id))
+		case verificationItemsDiffer, verificationFieldsDiffer:
+			if len(transaction.MissingItems) > 0 {
+				add("missing-items", "error", id, fmt.Sprintf("Transaction #%s is missing %d package(s) on server:\n%s", id, len(transaction.MissingItems), formatPackages("-", transaction.MissingItems)))
+			}
+			if len(transaction.ExtraItems) > 0 {
+				add("extra-items", "warning", id, fmt.Sprintf("Transaction #%s has %d extra package(s) on server:\n%s", id, len(transaction.ExtraItems), formatPackages("+", transaction.ExtraItems)))
+			}
+			if len(transaction.FieldMismatches) > 0 {
+				add("field-mismatch", "error", id, fmt.Sprintf("Transaction #%s has %d field(s) different on server:\n%s", id, len(transaction.FieldMismatches), formatFieldMismatches(transaction.FieldMismatches)))
+			}
+		case verificationUnverified:
+			add("unverified", "note", id, fmt.Sprintf("Transaction #%s could not be verified: %s", id, transaction.Error))
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]interface{}{{
+			"tool": map[string]interface{}{
+				"driver": map[string]interface{}{
+					"name":           "txlog",
+					"version":        agentVersion,
+					"informationUri": "https://txlog.rda.run",
+					"rules":          sarifRules,
+				},
+			},
+			"results": results,
+			"properties": map[string]interface{}{
+				"machine_id":                result.MachineID,
+				"hostname":                  result.Hostname,
+				"total_local_transactions":  result.TotalLocalTransactions,
+				"total_server_transactions": result.TotalServerTransactions,
+				"fully_verified":            result.FullyVerified,
+			},
+		}},
+	}
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func testVerificationResult() *VerificationResult {
+	git := Package{Action: "Install", Name: "git", Version: "2.31", Release: "1.el8", Arch: "x86_64", Repo: "appstream"}
+	return &VerificationResult{
+		MachineID:               "machine",
+		Hostname:                "host",
+		TotalLocalTransactions:  4,
+		TotalServerTransactions: 3,
+		MissingOnServer:         []string{"4"},
+		WithMissingItems:        []string{"2"},
+		WithExtraItems:          []string{"2"},
+		FullyVerified:           1,
+		Transactions: []TransactionVerification{
+			{TransactionID: "1", Status: verificationVerified},
+			{TransactionID: "2", Status: verificationItemsDiffer, MissingItems: []Package{git}, ExtraItems: []Package{git}},
+			{TransactionID: "3", Status: verificationUnverified, Error: "timeout"},
+			{TransactionID: "4", Status: verificationMissingOnServer},
+		},
+	}
+}
+
+func TestWriteVerificationReport_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeVerificationReport(&buf, "json", testVerificationResult()); err != nil {
+		t.Fatalf("writeVerificationReport() error = %v", err)
+	}
+
+	var decoded VerificationResult
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+	if len(decoded.Transactions) != 4 || len(decoded.Transactions[1].MissingItems) != 1 || decoded.Transactions[1].ExtraItems[0].Name != "git" {
+		t.Errorf("report transactions = %+v, want the packages of each transaction", decoded.Transactions)
+	}
+	if decoded.MachineID != "machine" || decoded.TotalLocalTransactions != 4 {
+		t.Errorf("report = %+v, want the totals of the result", decoded)
+	}
+}
+
+func TestWriteVerificationReport_JUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeVerificationReport(&buf, "junit", testVerificationResult()); err != nil {
+		t.Fatalf("writeVerificationReport() error = %v", err)
+	}
+
+	var decoded junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("report is not valid XML: %v", err)
+	}
+	if decoded.Tests != 4 || decoded.Failures != 2 || decoded.Skipped != 1 {
+		t.Errorf("report has %d tests, %d failures and %d skipped; want 4, 2 and 1", decoded.Tests, decoded.Failures, decoded.Skipped)
+	}
+
+	cases := decoded.Suites[0].Cases
+	if cases[1].Failure == nil || !strings.Contains(cases[1].Failure.Text, "- Install git-2.31-1.el8.x86_64") {
+		t.Errorf("transaction 2 failure = %+v, want its missing packages", cases[1].Failure)
+	}
+	if cases[3].Failure == nil || cases[3].Failure.Type != verificationMissingOnServer {
+		t.Errorf("transaction 4 failure = %+v, want missing on server", cases[3].Failure)
+	}
+}
+
+func TestWriteVerificationReport_SARIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeVerificationReport(&buf, "sarif", testVerificationResult()); err != nil {
+		t.Fatalf("writeVerificationReport() error = %v", err)
+	}
+
+	var decoded struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Results []struct {
+				RuleID string `json:"ruleId"`
+				Level  string `json:"level"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+	if decoded.Version != "2.1.0" || len(decoded.Runs) != 1 {
+		t.Fatalf("report = %+v, want a single SARIF 2.1.0 run", decoded)
+	}
+
+	var got []string
+	for _, result := range decoded.Runs[0].Results {
+		got = append(got, result.RuleID+":"+result.Level)
+	}
+	want := "missing-items:error extra-items:warning unverified:note missing-on-server:error"
+	if strings.Join(got, " ") != want {
+		t.Errorf("report results = %v, want %s", got, want)
+	}
+}
+
+func TestWriteVerificationReport_UnknownFormat(t *testing.T) {
+	if err := writeVerificationReport(&bytes.Buffer{}, "csv", testVerificationResult()); err == nil {
+		t.Error("writeVerificationReport() should reject an unknown format")
+	}
+}
+
+func TestWriteVerificationReport_FieldMismatches(t *testing.T) {
+	result := &VerificationResult{
+		Hostname:            "host",
+		WithFieldMismatches: []string{"1"},
+		Transactions: []TransactionVerification{{
+			TransactionID:   "1",
+			Status:          verificationFieldsDiffer,
+			FieldMismatches: []FieldMismatch{{Field: "begin_time", Local: "2024-03-10T10:00:00Z", Server: "2024-03-10T13:00:00Z"}},
+		}},
+	}
+
+	var junit bytes.Buffer
+	if err := writeVerificationReport(&junit, "junit", result); err != nil {
+		t.Fatalf("writeVerificationReport() error = %v", err)
+	}
+	if !strings.Contains(junit.String(), `~ begin_time: &#34;2024-03-10T10:00:00Z&#34; locally, &#34;2024-03-10T13:00:00Z&#34; on server`) {
+		t.Errorf("JUnit report does not list the field mismatch:\n%s", junit.String())
+	}
+
+	var sarif bytes.Buffer
+	if err := writeVerificationReport(&sarif, "sarif", result); err != nil {
+		t.Fatalf("writeVerificationReport() error = %v", err)
+	}
+	if !strings.Contains(sarif.String(), `"ruleId": "field-mismatch"`) {
+		t.Errorf("SARIF report does not report the field mismatch:\n%s", sarif.String())
+	}
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itlightning/dateparse"
+	"github.com/spf13/cobra"
+)
+
+// verifyScope selects the local transactions verify checks. Its zero value
+// selects the whole history.
+type verifyScope struct {
+	// fromID and toID bound the transaction IDs, inclusive, or are 0 for no bound
+	fromID, toID int
+	// since and until bound the begin time of the transactions, since
+	// inclusive and until exclusive, or are zero for no bound
+	since, until time.Time
+	// last keeps only the last transactions selected, or is 0 to keep all
+	last int
+	// sample keeps that many transactions selected at random, or is 0 to
+	// keep all
+	sample int
+}
+
+// whole reports whether the scope selects the whole history.
+func (s verifyScope) whole() bool {
+	return s == verifyScope{}
+}
+
+// addScopeFlags registers the flags selecting the transactions to verify.
+func addScopeFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("from-id", 0, "only verify transactions with this ID or higher")
+	cmd.Flags().Int("to-id", 0, "only verify transactions with this ID or lower")
+	cmd.Flags().String("since", "", "only verify transactions that began at or after this time, or this long ago (e.g. 24h, 7d)")
+	cmd.Flags().String("until", "", "only verify transactions that began before this time, or this long ago (e.g. 24h, 7d)")
+	cmd.Flags().Int("last", 0, "only verify the last N transactions selected")
+	cmd.Flags().Int("sample", 0, "only verify N transactions selected at random")
+}
+
+// scopeFromFlags returns the scope selected by the flags added by
+// addScopeFlags, with relative times computed from now.
+func scopeFromFlags(cmd *cobra.Command, now time.Time) (verifyScope, error) {
+	var scope verifyScope
+	scope.fromID, _ = cmd.Flags().GetInt("from-id")
+	scope.toID, _ = cmd.Flags().GetInt("to-id")
+	scope.last, _ = cmd.Flags().GetInt("last")
+	scope.sample, _ = cmd.Flags().GetInt("sample")
+
+	for _, flag := range []struct {
+		name  string
+		value int
+	}{{"--from-id", scope.fromID}, {"--to-id", scope.toID}, {"--last", scope.last}, {"--sample", scope.sample}} {
+		if flag.value < 0 {
+			return verifyScope{}, fmt.Errorf("invalid %s %d, must be positive", flag.name, flag.value)
+		}
+	}
+	if scope.toID > 0 && scope.fromID > scope.toID {
+		return verifyScope{}, fmt.Errorf("--from-id %d is greater than --to-id %d", scope.fromID, scope.toID)
+	}
+
+	var err error
+	since, _ := cmd.Flags().GetString("since")
+	if scope.since, err = parseTimeBound(since, now); err != nil {
+		return verifyScope{}, fmt.Errorf("invalid --since: %w", err)
+	}
+	until, _ := cmd.Flags().GetString("until")
+	if scope.until, err = parseTimeBound(until, now); err != nil {
+		return verifyScope{}, fmt.Errorf("invalid --until: %w", err)
+	}
+	if !scope.since.IsZero() && !scope.until.IsZero() && !scope.since.Before(scope.until) {
+		return verifyScope{}, errors.New("--since must be before --until")
+	}
+
+	return scope, nil
+}
+
+// parseTimeBound parses a time given as a date, such as "2024-03-10" or
+// "2024-03-10 12:00", in the local time zone unless it has one, or as a
+// duration before now, such as "90m", "24h" or "7d". An empty value returns
+// the zero time.
+func parseTimeBound(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if days, found := strings.CutSuffix(value, "d"); found {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+
+	t, err := dateparse.ParseLocal(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a date nor a duration", value)
+	}
+	return t, nil
+}
+
+// errScanDone stops the scan of the history in selectTransactions.
+var errScanDone = errors.New("scan done")
+
+// selectTransactions returns the transactions of transactionIDs selected by
+// scope, in ascending order. The ID range is applied first, then the time
+// window, then --last and finally --sample.
+//
+// The time window needs the begin time of each transaction, which is read
+// from the history source from the newest transaction to the oldest. As
+// transaction IDs grow with time, the scan stops at the first transaction
+// that began before scope.since. Transactions whose begin time cannot be
+// read are kept, so that verify reports them rather than skipping them. The
+// details of the selected transactions read are returned by ID, so that
+// verify does not read them again.
+func selectTransactions(source HistorySource, transactionIDs []int, scope verifyScope) ([]int, map[string]TransactionDetail, error) {
+	selected := make([]int, 0, len(transactionIDs))
+	for _, id := range transactionIDs {
+		if id >= scope.fromID && (scope.toID == 0 || id <= scope.toID) {
+			selected = append(selected, id)
+		}
+	}
+	slices.Sort(selected)
+
+	var details map[string]TransactionDetail
+	if !scope.since.IsZero() || !scope.until.IsZero() {
+		newest := make([]string, len(selected))
+		for i, id := range selected {
+			newest[len(selected)-1-i] = strconv.Itoa(id)
+		}
+
+		var inWindow []int
+		details = make(map[string]TransactionDetail)
+		err := eachTransaction(source, newest, func(transactionID string, transaction TransactionDetail, err error) error {
+			id, _ := strconv.Atoi(transactionID)
+			if err != nil {
+				inWindow = append(inWindow, id)
+				return nil
+			}
+			begin, err := dateparse.ParseLocal(strings.TrimSpace(transaction.BeginTime))
+			switch {
+			case err != nil:
+				inWindow = append(inWindow, id)
+				details[transactionID] = transaction
+			case !scope.since.IsZero() && begin.Before(scope.since):
+				return errScanDone
+			case scope.until.IsZero() || begin.Before(scope.until):
+				inWindow = append(inWindow, id)
+				details[transactionID] = transaction
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errScanDone) {
+			return nil, nil, err
+		}
+
+		slices.Reverse(inWindow)
+		selected = inWindow
+	}
+
+	if scope.last > 0 && len(selected) > scope.last {
+		selected = selected[len(selected)-scope.last:]
+	}
+
+	if scope.sample > 0 && len(selected) > scope.sample {
+		selected = slices.Clone(selected)
+		rand.Shuffle(len(selected), func(i, j int) {
+			selected[i], selected[j] = selected[j], selected[i]
+		})
+		selected = selected[:scope.sample]
+		slices.Sort(selected)
+	}
+
+	// The details of the transactions left out are not kept
+	if len(details) > len(selected) {
+		kept := make(map[string]TransactionDetail, len(selected))
+		for _, id := range selected {
+			transactionID := strconv.Itoa(id)
+			if transaction, found := details[transactionID]; found {
+				kept[transactionID] = transaction
+			}
+		}
+		details = kept
+	}
+
+	return selected, details, nil
+}
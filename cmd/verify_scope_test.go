@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// datedHistorySource returns the transactions of its entries with the begin
+// time in beginTimes, and records the transactions read.
+type datedHistorySource struct {
+	stubHistorySource
+	beginTimes map[string]string
+	read       []string
+}
+
+func (s *datedHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	s.read = append(s.read, transactionID)
+	return TransactionDetail{TransactionID: transactionID, BeginTime: s.beginTimes[transactionID]}, nil
+}
+
+// newDatedHistorySource returns a history of transactions 1 to 10, the
+// transaction n beginning on March n, 2024, at noon UTC.
+func newDatedHistorySource() *datedHistorySource {
+	source := &datedHistorySource{beginTimes: map[string]string{}}
+	for id := 10; id >= 1; id-- {
+		transactionID := strconv.Itoa(id)
+		source.entries = append(source.entries, HistoryEntry{TransactionID: transactionID})
+		source.beginTimes[transactionID] = time.Date(2024, 3, id, 12, 0, 0, 0, time.UTC).Format(time.RFC3339)
+	}
+	return source
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"", time.Time{}},
+		{"24h", now.Add(-24 * time.Hour)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"7d", time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)},
+		{"2024-03-01T08:00:00Z", time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)},
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeBound(tt.value, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseTimeBound(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+		}
+	}
+
+	if _, err := parseTimeBound("yesterday-ish", now); err == nil {
+		t.Error("parseTimeBound() should reject a value that is neither a date nor a duration")
+	}
+}
+
+func TestScopeFromFlags(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		args    []string
+		want    verifyScope
+		wantErr bool
+	}{
+		{nil, verifyScope{}, false},
+		{[]string{"--from-id", "3", "--to-id", "8", "--last", "2"}, verifyScope{fromID: 3, toID: 8, last: 2}, false},
+		{[]string{"--since", "24h", "--sample", "5"}, verifyScope{since: now.Add(-24 * time.Hour), sample: 5}, false},
+		{[]string{"--from-id", "8", "--to-id", "3"}, verifyScope{}, true},
+		{[]string{"--last", "-1"}, verifyScope{}, true},
+		{[]string{"--since", "1h", "--until", "2h"}, verifyScope{}, true},
+		{[]string{"--until", "someday"}, verifyScope{}, true},
+	}
+
+	for _, tt := range tests {
+		cmd := &cobra.Command{}
+		addScopeFlags(cmd)
+		if err := cmd.ParseFlags(tt.args); err != nil {
+			t.Fatalf("ParseFlags(%v) error = %v", tt.args, err)
+		}
+
+		got, err := scopeFromFlags(cmd, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("scopeFromFlags(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("scopeFromFlags(%v) = %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSelectTransactions(t *testing.T) {
+	march := func(day int) time.Time { return time.Date(2024, 3, day, 12, 0, 0, 0, time.UTC) }
+	all := []int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+
+	tests := []struct {
+		name     string
+		scope    verifyScope
+		want     []int
+		wantRead []string
+	}{
+		{"ID range", verifyScope{fromID: 3, toID: 5}, []int{3, 4, 5}, nil},
+		{"from ID", verifyScope{fromID: 9}, []int{9, 10}, nil},
+		{"last", verifyScope{last: 3}, []int{8, 9, 10}, nil},
+		{"since", verifyScope{since: march(8)}, []int{8, 9, 10}, []string{"10", "9", "8", "7"}},
+		{"until", verifyScope{until: march(3)}, []int{1, 2}, nil},
+		{"time window", verifyScope{since: march(4), until: march(6)}, []int{4, 5}, nil},
+		{"ID range, then time window, then last", verifyScope{toID: 8, since: march(3), last: 2}, []int{7, 8}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newDatedHistorySource()
+			got, details, err := selectTransactions(source, all, tt.scope)
+			if err != nil {
+				t.Fatalf("selectTransactions() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectTransactions() = %v, want %v", got, tt.want)
+			}
+			for transactionID := range details {
+				id, _ := strconv.Atoi(transactionID)
+				if !slices.Contains(got, id) {
+					t.Errorf("details of transaction %s returned, want only the selected ones", transactionID)
+				}
+			}
+			if len(source.read) > 0 && len(details) != len(got) {
+				t.Errorf("details of %d transactions returned, want the %d selected", len(details), len(got))
+			}
+			if tt.wantRead != nil && !reflect.DeepEqual(source.read, tt.wantRead) {
+				t.Errorf("transactions read = %v, want %v: the scan should stop at the first one before --since", source.read, tt.wantRead)
+			}
+		})
+	}
+}
+
+func TestSelectTransactions_Sample(t *testing.T) {
+	all := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	got, _, err := selectTransactions(newDatedHistorySource(), all, verifyScope{fromID: 3, sample: 4})
+	if err != nil {
+		t.Fatalf("selectTransactions() error = %v", err)
+	}
+	if len(got) != 4 || !slices.IsSorted(got) || len(slices.Compact(slices.Clone(got))) != 4 {
+		t.Fatalf("selectTransactions() = %v, want 4 distinct transactions in ascending order", got)
+	}
+	for _, id := range got {
+		if id < 3 {
+			t.Errorf("sampled transaction %d is out of the ID range", id)
+		}
+	}
+
+	got, _, _ = selectTransactions(newDatedHistorySource(), all, verifyScope{sample: 20})
+	if !reflect.DeepEqual(got, all) {
+		t.Errorf("selectTransactions() = %v, want the whole history when sampling more than it has", got)
+	}
+}
+
+func TestVerifyTransactions_Scope(t *testing.T) {
+	var itemRequests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/transactions/ids":
+			// Transaction 7 was never sent
+			json.NewEncoder(w).Encode([]int{1, 2, 3, 4, 5, 6, 8, 9, 10})
+		case "/v1/items":
+			itemRequests = append(itemRequests, r.URL.Query().Get("transaction_id"))
+			json.NewEncoder(w).Encode(ServerTransaction{})
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	result, err := verifyTransactions(newDatedHistorySource(), "machine", "host", verifyOptions{scope: verifyScope{fromID: 6, last: 3}})
+	if err != nil {
+		t.Fatalf("verifyTransactions() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(itemRequests, []string{"8", "9", "10"}) {
+		t.Errorf("items requested for %v, want the transactions in scope", itemRequests)
+	}
+	if !reflect.DeepEqual(result.MissingOnServer, []string{}) {
+		t.Errorf("MissingOnServer = %v, want none: transaction 7 is out of scope", result.MissingOnServer)
+	}
+	if result.TotalLocalTransactions != 3 || result.TotalServerTransactions != 3 || len(result.Transactions) != 3 {
+		t.Errorf("result = %+v, want 3 local and 3 server transactions in scope", result)
+	}
+}
+
+func TestVerifyTransactions_TimeWindowReadsOnce(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/transactions/ids":
+			json.NewEncoder(w).Encode([]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+		case "/v1/items":
+			json.NewEncoder(w).Encode(ServerTransaction{})
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	source := newDatedHistorySource()
+	scope := verifyScope{since: time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)}
+	if _, err := verifyTransactions(source, "machine", "host", verifyOptions{scope: scope}); err != nil {
+		t.Fatalf("verifyTransactions() error = %v", err)
+	}
+
+	// The transactions read to select them are not read again
+	if want := []string{"10", "9", "8", "7"}; !reflect.DeepEqual(source.read, want) {
+		t.Errorf("transactions read = %v, want %v", source.read, want)
+	}
+}
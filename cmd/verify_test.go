@@ -1,7 +1,16 @@
 package cmd
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/spf13/viper"
 )
 
 func TestCompareTransactionItems(t *testing.T) {
@@ -110,6 +119,17 @@ func TestCompareTransactionItems(t *testing.T) {
 			wantMissing: 1,
 			wantExtra:   0,
 		},
+		{
+			name: "replaced package sent by older agents with its repository in Repo",
+			localPackages: []Package{
+				{Action: "Upgraded", Name: "openssl", Version: "1:3.0.7", Release: "24.el9", Arch: "x86_64", FromRepo: "System"},
+			},
+			serverPackages: []Package{
+				{Action: "Upgraded", Name: "openssl", Version: "1:3.0.7", Release: "24.el9", Arch: "x86_64", Repo: "@System"},
+			},
+			wantMissing: 0,
+			wantExtra:   0,
+		},
 	}
 
 	for _, tt := range tests {
@@ -231,3 +251,206 @@ func TestPrintVerificationResults(t *testing.T) {
 		})
 	}
 }
+
+// itemsHistorySource returns the transactions of its entries with the
+// packages in items, and counts the transactions read.
+type itemsHistorySource struct {
+	stubHistorySource
+	items map[string][]Package
+	read  atomic.Int32
+}
+
+func (s *itemsHistorySource) Transaction(transactionID string) (TransactionDetail, error) {
+	s.read.Add(1)
+	return TransactionDetail{TransactionID: transactionID, PackagesAltered: s.items[transactionID]}, nil
+}
+
+func TestVerifyTransactions(t *testing.T) {
+	vim := Package{Action: "Install", Name: "vim", Version: "8.2", Release: "1.el8", Arch: "x86_64", Repo: "appstream"}
+	git := Package{Action: "Install", Name: "git", Version: "2.31", Release: "1.el8", Arch: "x86_64", Repo: "appstream"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/transactions/ids":
+			json.NewEncoder(w).Encode([]int{1, 2, 3, 4})
+		case "/v1/items":
+			items := map[string][]Package{"1": {vim}, "2": {vim, git}, "3": {}}[r.URL.Query().Get("transaction_id")]
+			if r.URL.Query().Get("transaction_id") == "4" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(ServerTransaction{Items: items})
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	viper.Set("server.retry.max_attempts", 1)
+	defer viper.Reset()
+
+	source := &itemsHistorySource{items: map[string][]Package{"1": {vim}, "2": {vim}, "3": {git}, "4": {vim}}}
+	for _, id := range []string{"5", "4", "3", "2", "1"} {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: id})
+	}
+
+	result, err := verifyTransactions(source, "machine", "host", verifyOptions{})
+	if err != nil {
+		t.Fatalf("verifyTransactions() error = %v", err)
+	}
+
+	want := []TransactionVerification{
+		{TransactionID: "1", Status: verificationVerified, MissingItems: []Package{}, ExtraItems: []Package{}},
+		{TransactionID: "2", Status: verificationItemsDiffer, MissingItems: []Package{}, ExtraItems: []Package{git}},
+		{TransactionID: "3", Status: verificationItemsDiffer, MissingItems: []Package{git}, ExtraItems: []Package{}},
+		{TransactionID: "4", Status: verificationUnverified},
+		{TransactionID: "5", Status: verificationMissingOnServer},
+	}
+	if len(result.Transactions) != len(want) {
+		t.Fatalf("Transactions = %+v, want %d transactions", result.Transactions, len(want))
+	}
+	for i := range want {
+		got := result.Transactions[i]
+		got.Error = ""
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("Transactions[%d] = %+v, want %+v", i, got, want[i])
+		}
+	}
+	if result.Transactions[3].Error == "" {
+		t.Error("unverified transaction 4 should record its error")
+	}
+	if result.MachineID != "machine" || result.Hostname != "host" || result.FullyVerified != 1 {
+		t.Errorf("result = %+v, want machine, host and 1 fully verified", result)
+	}
+}
+
+func TestVerifyTransactions_Concurrency(t *testing.T) {
+	vim := Package{Action: "Install", Name: "vim", Version: "8.2", Release: "1.el8", Arch: "x86_64", Repo: "appstream"}
+	git := Package{Action: "Install", Name: "git", Version: "2.31", Release: "1.el8", Arch: "x86_64", Repo: "appstream"}
+
+	source := &itemsHistorySource{items: map[string][]Package{}}
+	serverIDs := []int{}
+	for id := 40; id >= 1; id-- {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: strconv.Itoa(id)})
+		source.items[strconv.Itoa(id)] = []Package{vim}
+		serverIDs = append(serverIDs, id)
+	}
+
+	var running, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/transactions/ids":
+			json.NewEncoder(w).Encode(serverIDs)
+		case "/v1/items":
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			// Every third transaction lost vim for git on the server
+			id, _ := strconv.Atoi(r.URL.Query().Get("transaction_id"))
+			items := []Package{vim}
+			if id%3 == 0 {
+				items = []Package{git}
+			}
+			json.NewEncoder(w).Encode(ServerTransaction{Items: items})
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	sequential, err := verifyTransactions(source, "machine", "host", verifyOptions{concurrency: 1})
+	if err != nil {
+		t.Fatalf("verifyTransactions() error = %v", err)
+	}
+	peak.Store(0)
+	parallel, err := verifyTransactions(source, "machine", "host", verifyOptions{concurrency: 8})
+	if err != nil {
+		t.Fatalf("verifyTransactions() error = %v", err)
+	}
+
+	if peak.Load() < 2 {
+		t.Errorf("at most %d transactions verified at the same time, want several", peak.Load())
+	}
+	if !reflect.DeepEqual(parallel, sequential) {
+		t.Errorf("verification with 8 workers = %+v, want the same as with 1: %+v", parallel, sequential)
+	}
+	if len(parallel.WithMissingItems) != 13 || parallel.WithMissingItems[0] != "3" || parallel.WithMissingItems[12] != "39" {
+		t.Errorf("WithMissingItems = %v, want every third transaction in ascending order", parallel.WithMissingItems)
+	}
+	if parallel.FullyVerified != 27 {
+		t.Errorf("FullyVerified = %d, want 27", parallel.FullyVerified)
+	}
+}
+
+func TestCompareTransactionFields(t *testing.T) {
+	local := TransactionDetail{
+		TransactionID: "7",
+		BeginTime:     "2024-03-10T12:00:00+02:00",
+		EndTime:       "2024-03-10T12:00:05+02:00",
+		User:          "root <root>",
+		ReturnCode:    "Success",
+		Releasever:    "9",
+		CommandLine:   "install vim",
+	}
+	server := ServerTransaction{
+		TransactionID:  "7",
+		BeginTime:      "2024-03-10T10:00:00Z",
+		EndTime:        "2024-03-10 10:00:05 +0000 UTC",
+		User:           "root <root> ",
+		ReturnCode:     "Success",
+		ReleaseVersion: "9",
+		CommandLine:    "install vim",
+	}
+
+	if mismatches := compareTransactionFields(local, &server); len(mismatches) != 0 {
+		t.Errorf("compareTransactionFields() = %+v, want none for the same values in other formats", mismatches)
+	}
+
+	shifted := server
+	shifted.BeginTime = "2024-03-10T12:00:00Z"
+	shifted.ReleaseVersion = "8"
+	want := []FieldMismatch{
+		{Field: "begin_time", Local: local.BeginTime, Server: shifted.BeginTime},
+		{Field: "release_version", Local: "9", Server: "8"},
+	}
+	if mismatches := compareTransactionFields(local, &shifted); !reflect.DeepEqual(mismatches, want) {
+		t.Errorf("compareTransactionFields() = %+v, want %+v", mismatches, want)
+	}
+
+	itemsOnly := ServerTransaction{Items: []Package{}}
+	if mismatches := compareTransactionFields(local, &itemsOnly); len(mismatches) != 0 {
+		t.Errorf("compareTransactionFields() = %+v, want none when the server only returns items", mismatches)
+	}
+}
+
+func TestSameTime(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"2024-03-10T12:00:00+02:00", "2024-03-10T10:00:00Z", true},
+		{"2024-03-10T10:00:00.4Z", "2024-03-10T10:00:00Z", true},
+		{"2024-03-10T10:00:00Z", "2024-03-10T11:00:00Z", false},
+		{"", "", true},
+		{"2024-03-10T10:00:00Z", "", false},
+		{"not a time", "not a time", true},
+		{"not a time", "2024-03-10T10:00:00Z", false},
+	}
+
+	for _, tt := range tests {
+		if got := sameTime(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameTime(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
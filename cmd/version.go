@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
@@ -51,6 +52,8 @@ var versionCmd = &cobra.Command{
 			fmt.Printf("🖥️ Txlog Server: %s\n", color.YellowString("unknown"))
 		}
 
+		printSpoolStatus()
+
 		fmt.Println(strings.Repeat("=", 60))
 
 		// Check for updates
@@ -84,14 +87,42 @@ func init() {
 	rootCmd.AddCommand(versionCmd)
 }
 
+// serverVersions holds the outcome of the version requests made to each
+// server URL, as the server version gates several features of a run.
+var serverVersions = struct {
+	sync.Mutex
+	results map[string]serverVersionResult
+}{results: make(map[string]serverVersionResult)}
+
+// serverVersionResult is the outcome of a version request.
+type serverVersionResult struct {
+	version string
+	err     error
+}
+
 // GetServerVersionWithError retrieves the server version and returns detailed error information.
+// The server is only asked once per run, later calls return the same result.
 // Returns the version string and any error encountered.
 // If successful, returns version and nil error.
 // If there's an authentication error, returns empty string and ServerVersionError with status code.
-// If there's a network error, returns empty string and the network error.
+// If there's a network error, returns empty string and a ServerUnavailableError.
 func GetServerVersionWithError() (string, error) {
-	client := resty.New()
-	client.SetTimeout(30 * time.Second)
+	url := viper.GetString("server.url")
+
+	serverVersions.Lock()
+	defer serverVersions.Unlock()
+	if result, ok := serverVersions.results[url]; ok {
+		return result.version, result.err
+	}
+
+	version, err := fetchServerVersion(url)
+	serverVersions.results[url] = serverVersionResult{version: version, err: err}
+	return version, err
+}
+
+// fetchServerVersion requests the version of the server at url.
+func fetchServerVersion(url string) (string, error) {
+	client := util.NewServerClient()
 	client.SetAllowGetMethodPayload(true)
 
 	var server ServerVersion
@@ -101,11 +132,11 @@ func GetServerVersionWithError() (string, error) {
 
 	util.SetAuthentication(req)
 
-	resp, err := req.Get(viper.GetString("server.url") + "/v1/version")
+	resp, err := req.Get(url + "/v1/version")
 
 	// Network error
 	if err != nil {
-		return "", fmt.Errorf("failed to connect to server: %w", err)
+		return "", &ServerUnavailableError{Err: fmt.Errorf("failed to connect to server: %w", err)}
 	}
 
 	// Authentication or other HTTP errors
@@ -245,6 +276,7 @@ func CheckUpdateSource(packageName string) string {
 	}
 
 	cmd := exec.CommandContext(ctx, "dnf", args...)
+	util.SetCLocale(cmd)
 
 	// Execute the command
 	err := cmd.Run()
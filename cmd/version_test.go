@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/spf13/viper"
 )
 
 // TestVersionComparison tests the semver logic used in ValidateServerVersionForAPIKey
@@ -135,3 +139,26 @@ func TestCheckUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestGetServerVersion_OncePerRun(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ServerVersion{Version: "1.23.0"})
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	for i := 0; i < 3; i++ {
+		if version := GetServerVersion(); version != "1.23.0" {
+			t.Errorf("GetServerVersion() = %q, want %q", version, "1.23.0")
+		}
+	}
+	if requests != 1 {
+		t.Errorf("server version requested %d times, want 1", requests)
+	}
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// defaultConcurrency is used when neither the --concurrency flag nor the
+// agent.concurrency setting are set: transactions are handled one at a time.
+const defaultConcurrency = 1
+
+// maxConcurrency caps the number of workers, so that a typo cannot flood the
+// server or fork hundreds of package manager processes.
+const maxConcurrency = 64
+
+// addConcurrencyFlag registers the --concurrency flag on a command.
+func addConcurrencyFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().Int("concurrency", 0, usage+" (default is agent.concurrency or 1)")
+}
+
+// concurrency returns the number of workers selected by the --concurrency
+// flag, falling back to the agent.concurrency setting and then to 1.
+func concurrency(cmd *cobra.Command) (int, error) {
+	n, _ := cmd.Flags().GetInt("concurrency")
+	if !cmd.Flags().Changed("concurrency") {
+		n = defaultConcurrency
+		if viper.IsSet("agent.concurrency") {
+			n = viper.GetInt("agent.concurrency")
+		}
+	}
+
+	if n < 1 || n > maxConcurrency {
+		return 0, fmt.Errorf("concurrency must be between 1 and %d, got %d", maxConcurrency, n)
+	}
+
+	return n, nil
+}
+
+// runOrdered calls work for each index from 0 to count-1 on up to concurrency
+// goroutines, and emit with each result in index order, from the calling
+// goroutine. Whatever the concurrency, emit sees the same sequence, so the
+// output and counters it produces stay deterministic.
+//
+// Workers run at most 2*concurrency indexes ahead of emit, which bounds the
+// results held in memory. runOrdered stops dispatching work at the first
+// error returned by emit, waits for the work in progress and returns it.
+func runOrdered[T any](concurrency, count int, work func(i int) T, emit func(i int, result T) error) error {
+	concurrency = max(concurrency, 1)
+
+	results := make([]chan T, count)
+	for i := range results {
+		results[i] = make(chan T, 1)
+	}
+
+	next := make(chan int)
+	window := make(chan struct{}, 2*concurrency)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range min(concurrency, count) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] <- work(i)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(next)
+		for i := range count {
+			select {
+			case window <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case next <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var err error
+	for i := range count {
+		result := <-results[i]
+		<-window
+		if err = emit(i, result); err != nil {
+			break
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	return err
+}
+
+// rateLimiter spaces out requests so that no more than a given number start
+// per second, whatever the number of goroutines sending them. It is safe for
+// concurrent use, and a nil rateLimiter does not limit anything.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter returns a limiter of perSecond requests per second, or nil
+// when perSecond is not positive.
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next request may start.
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// limitRate makes client send at most perSecond requests per second, retries
+// included. A perSecond that is not positive leaves client unlimited.
+func limitRate(client *resty.Client, perSecond float64) *resty.Client {
+	limiter := newRateLimiter(perSecond)
+	if limiter != nil {
+		client.OnBeforeRequest(func(*resty.Client, *resty.Request) error {
+			limiter.wait()
+			return nil
+		})
+	}
+	return client
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func TestRunOrdered(t *testing.T) {
+	var running, peak atomic.Int32
+
+	var got []int
+	err := runOrdered(4, 100, func(i int) int {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		// Later indexes finish first
+		time.Sleep(time.Duration(100-i) * 10 * time.Microsecond)
+		running.Add(-1)
+		return i * i
+	}, func(i, result int) error {
+		if result != i*i {
+			t.Errorf("result %d: got %d", i, result)
+		}
+		got = append(got, i)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runOrdered() error = %v", err)
+	}
+
+	for i := range got {
+		if got[i] != i {
+			t.Fatalf("runOrdered() emitted %v, want indexes in order", got)
+		}
+	}
+	if len(got) != 100 {
+		t.Errorf("runOrdered() emitted %d results, want 100", len(got))
+	}
+	if peak.Load() > 4 {
+		t.Errorf("runOrdered() ran %d workers at once, want at most 4", peak.Load())
+	}
+}
+
+func TestRunOrdered_StopsOnError(t *testing.T) {
+	var worked atomic.Int32
+
+	err := runOrdered(2, 1000, func(i int) int {
+		worked.Add(1)
+		return i
+	}, func(i, result int) error {
+		if i == 3 {
+			return errTest
+		}
+		return nil
+	})
+	if err != errTest {
+		t.Fatalf("runOrdered() error = %v, want %v", err, errTest)
+	}
+	if n := worked.Load(); n > 3+1+2*2 {
+		t.Errorf("runOrdered() did %d works after the error, want dispatch to stop", n)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	newCmd := func() *cobra.Command {
+		cmd := &cobra.Command{}
+		addConcurrencyFlag(cmd, "test")
+		return cmd
+	}
+
+	viper.Reset()
+	defer viper.Reset()
+
+	cmd := newCmd()
+	if n, err := concurrency(cmd); n != 1 || err != nil {
+		t.Errorf("concurrency() = %d, %v; want 1 by default", n, err)
+	}
+
+	viper.Set("agent.concurrency", 8)
+	if n, err := concurrency(cmd); n != 8 || err != nil {
+		t.Errorf("concurrency() = %d, %v; want 8 from the configuration", n, err)
+	}
+
+	cmd.Flags().Set("concurrency", "3")
+	if n, err := concurrency(cmd); n != 3 || err != nil {
+		t.Errorf("concurrency() = %d, %v; want 3 from the flag", n, err)
+	}
+
+	cmd = newCmd()
+	cmd.Flags().Set("concurrency", "0")
+	if _, err := concurrency(cmd); err == nil {
+		t.Error("concurrency() should reject 0")
+	}
+}
+
+func TestSaveUnsentTransactions_Concurrent(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		received = append(received, body["transaction_id"].(string))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.url", server.URL)
+	defer viper.Reset()
+
+	source := &stubHistorySource{}
+	for i := 1; i <= 20; i++ {
+		source.entries = append(source.entries, HistoryEntry{TransactionID: strconv.Itoa(i)})
+	}
+	savedSet := map[string]struct{}{"1": {}, "2": {}}
+
+	processed, sent, failures, err := saveUnsentTransactions(source, nil, "machine", "host", savedSet, 4, 1, 0)
+	if err != nil {
+		t.Fatalf("saveUnsentTransactions() error = %v", err)
+	}
+	if processed != 20 || sent != 18 || len(failures) != 0 {
+		t.Errorf("saveUnsentTransactions() = %d processed, %d sent, %v failed; want 20, 18 and none", processed, sent, failures)
+	}
+
+	want := make(map[string]bool)
+	for i := 3; i <= 20; i++ {
+		want[strconv.Itoa(i)] = true
+	}
+	got := make(map[string]bool)
+	for _, id := range received {
+		got[id] = true
+	}
+	if len(received) != 18 || !reflect.DeepEqual(got, want) {
+		t.Errorf("server received %v, want each of transactions 3 to 20 once", received)
+	}
+}
+
+func TestLimitRate(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	client := limitRate(resty.New(), 50)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.R().Get(server.URL)
+		}()
+	}
+	wg.Wait()
+
+	// The first request starts at once, and the next five 20ms apart
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("6 requests at 50 per second took %v, want at least 100ms", elapsed)
+	}
+	if requests.Load() != 6 {
+		t.Errorf("server received %d requests, want 6", requests.Load())
+	}
+
+	if newRateLimiter(0) != nil {
+		t.Error("newRateLimiter(0) should not limit anything")
+	}
+}
@@ -4,7 +4,6 @@ package client
 import (
 	"encoding/json"
 	"fmt"
-	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/spf13/viper"
@@ -74,8 +73,7 @@ type Client struct {
 // New creates a new txlog server API client.
 func New() *Client {
 	baseURL := viper.GetString("server.url")
-	httpClient := resty.New()
-	httpClient.SetTimeout(30 * time.Second)
+	httpClient := util.NewServerClient()
 	httpClient.SetBaseURL(baseURL)
 
 	return &Client{
@@ -0,0 +1,242 @@
+// Package spool persists the payloads that could not be delivered to the
+// txlog server, so that they can be replayed once it is reachable again.
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RejectedDir is the subdirectory of the spool holding the payloads the
+// server rejected, kept for inspection but never replayed.
+const RejectedDir = "rejected"
+
+// Kinds of payloads kept in the spool.
+const (
+	KindTransaction = "transaction"
+	KindExecution   = "execution"
+)
+
+// Spool is a directory holding one JSON file per payload. File names start
+// with a sequence number, so that payloads are replayed in the order they
+// were added, followed by the kind and the key of the payload:
+//
+//	01718000000000000000-transaction-42.json
+type Spool struct {
+	dir string
+
+	mu sync.Mutex
+	// last is the highest sequence number in the spool, and paths the file
+	// of each payload by kind and key. Both are loaded from the directory
+	// by the first Add, then kept up to date, so that adding a payload does
+	// not list the directory again.
+	last  int64
+	paths map[string]string
+}
+
+// Entry is a payload kept in the spool.
+type Entry struct {
+	Kind      string
+	Key       string
+	CreatedAt time.Time
+	Size      int64
+
+	path string
+}
+
+// Stats summarizes the content of the spool.
+type Stats struct {
+	Count  int
+	Size   int64
+	Oldest time.Time
+}
+
+// Open returns the spool kept in dir, creating the directory if needed.
+func Open(dir string) (*Spool, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("spool directory is not set")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	return &Spool{dir: dir}, nil
+}
+
+// Dir returns the directory of the spool.
+func (s *Spool) Dir() string {
+	return s.dir
+}
+
+// Add stores a payload in the spool. A payload of the same kind and key
+// replaces the one already spooled, keeping its position, so that a payload
+// is never replayed twice.
+func (s *Spool) Add(kind, key string, body []byte) error {
+	if kind == "" || strings.Contains(kind, "-") || key == "" || strings.ContainsAny(key, `/\`) {
+		return fmt.Errorf("invalid spool entry %q/%q", kind, key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	path, exists := s.paths[kind+"-"+key]
+	if !exists {
+		seq := max(time.Now().UnixNano(), s.last+1)
+		s.last = seq
+		path = filepath.Join(s.dir, fmt.Sprintf("%020d-%s-%s.json", seq, kind, key))
+	}
+
+	// Write to a temporary file first, so that a crash never leaves a
+	// truncated payload in the spool
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.paths[kind+"-"+key] = path
+	return nil
+}
+
+// load reads the sequence numbers and files of the payloads in the spool,
+// unless already loaded. The caller must hold s.mu.
+func (s *Spool) load() error {
+	if s.paths != nil {
+		return nil
+	}
+
+	entries, err := s.Entries()
+	if err != nil {
+		return err
+	}
+
+	s.paths = make(map[string]string, len(entries))
+	for _, entry := range entries {
+		s.paths[entry.Kind+"-"+entry.Key] = entry.path
+		s.last = max(s.last, entry.CreatedAt.UnixNano())
+	}
+	return nil
+}
+
+// forget drops an entry from the loaded files, once it left the spool.
+func (s *Spool) forget(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.paths[entry.Kind+"-"+entry.Key] == entry.path {
+		delete(s.paths, entry.Kind+"-"+entry.Key)
+	}
+}
+
+// Entries lists the payloads in the spool, oldest first.
+func (s *Spool) Entries() ([]Entry, error) {
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || file.IsDir() {
+			continue
+		}
+
+		parts := strings.SplitN(name, "-", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		seq, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, Entry{
+			Kind:      parts[1],
+			Key:       parts[2],
+			CreatedAt: time.Unix(0, seq),
+			Size:      info.Size(),
+			path:      filepath.Join(s.dir, file.Name()),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	return entries, nil
+}
+
+// Read returns the payload of an entry.
+func (s *Spool) Read(entry Entry) ([]byte, error) {
+	return os.ReadFile(entry.path)
+}
+
+// Remove deletes an entry from the spool, usually after it was delivered.
+func (s *Spool) Remove(entry Entry) error {
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.forget(entry)
+	return nil
+}
+
+// Reject moves an entry the server rejected to the RejectedDir subdirectory
+// of the spool, where it is no longer replayed nor counted by Stats.
+func (s *Spool) Reject(entry Entry) error {
+	dir := filepath.Join(s.dir, RejectedDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if err := os.Rename(entry.path, filepath.Join(dir, filepath.Base(entry.path))); err != nil {
+		return err
+	}
+	s.forget(entry)
+	return nil
+}
+
+// Stats returns the number of payloads in the spool, their total size and
+// when the oldest one was added.
+func (s *Spool) Stats() (Stats, error) {
+	entries, err := s.Entries()
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{Count: len(entries)}
+	for _, entry := range entries {
+		stats.Size += entry.Size
+	}
+	if len(entries) > 0 {
+		stats.Oldest = entries[0].CreatedAt
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,194 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAdd(t *testing.T) {
+	sp, err := Open(filepath.Join(t.TempDir(), "spool"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, add := range []struct{ kind, key, body string }{
+		{KindTransaction, "2", `{"transaction_id":"2"}`},
+		{KindTransaction, "1", `{"transaction_id":"1"}`},
+		{KindExecution, "1", `{"success":false}`},
+		{KindTransaction, "2", `{"transaction_id":"2","user":"root"}`},
+	} {
+		if err := sp.Add(add.kind, add.key, []byte(add.body)); err != nil {
+			t.Fatalf("unexpected error adding %s %s: %v", add.kind, add.key, err)
+		}
+	}
+
+	entries, err := sp.Entries()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []struct{ kind, key string }{
+		{KindTransaction, "2"},
+		{KindTransaction, "1"},
+		{KindExecution, "1"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d: %+v", len(expected), len(entries), entries)
+	}
+	for i, e := range expected {
+		if entries[i].Kind != e.kind || entries[i].Key != e.key {
+			t.Errorf("entry %d: expected %s %s, got %s %s", i, e.kind, e.key, entries[i].Kind, entries[i].Key)
+		}
+	}
+
+	body, err := sp.Read(entries[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != `{"transaction_id":"2","user":"root"}` {
+		t.Errorf("expected the replaced payload, got %s", body)
+	}
+}
+
+func TestAdd_InvalidEntry(t *testing.T) {
+	sp, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, add := range []struct{ kind, key string }{
+		{"", "1"},
+		{KindTransaction, ""},
+		{"bad-kind", "1"},
+		{KindTransaction, "../1"},
+	} {
+		if err := sp.Add(add.kind, add.key, []byte("{}")); err == nil {
+			t.Errorf("expected error adding %q %q", add.kind, add.key)
+		}
+	}
+}
+
+func TestEntries_IgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"last_saved_id", ".tmp-123", "garbage.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("1"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sp.Add(KindTransaction, "1", []byte("{}")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := sp.Entries()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Key != "1" {
+		t.Errorf("expected only the spooled transaction, got %+v", entries)
+	}
+}
+
+func TestRemoveAndStats(t *testing.T) {
+	sp, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stats, err := sp.Stats()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Count != 0 || stats.Size != 0 || !stats.Oldest.IsZero() {
+		t.Errorf("expected empty stats, got %+v", stats)
+	}
+
+	sp.Add(KindTransaction, "1", []byte("1234"))
+	sp.Add(KindTransaction, "2", []byte("123456"))
+
+	stats, err = sp.Stats()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Count != 2 || stats.Size != 10 {
+		t.Errorf("expected 2 payloads of 10 bytes, got %+v", stats)
+	}
+
+	entries, _ := sp.Entries()
+	if !stats.Oldest.Equal(entries[0].CreatedAt) {
+		t.Errorf("expected oldest %v, got %v", entries[0].CreatedAt, stats.Oldest)
+	}
+
+	if err := sp.Remove(entries[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := sp.Remove(entries[0]); err != nil {
+		t.Errorf("removing a removed entry should not fail: %v", err)
+	}
+
+	stats, _ = sp.Stats()
+	if stats.Count != 1 || stats.Size != 6 {
+		t.Errorf("expected 1 payload of 6 bytes, got %+v", stats)
+	}
+}
+
+func TestAdd_KeepsOrderAcrossOpens(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sp.Add(KindTransaction, "1", []byte("{}"))
+	sp.Add(KindTransaction, "2", []byte("{}"))
+
+	// A spool opened later, as by the next build, replaces the payloads
+	// already spooled and adds the new ones after them
+	sp, err = Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sp.Add(KindTransaction, "3", []byte("{}"))
+	sp.Add(KindTransaction, "1", []byte(`{"user":"root"}`))
+
+	entries, _ := sp.Entries()
+	if len(entries) != 3 || entries[0].Key != "1" || entries[1].Key != "2" || entries[2].Key != "3" {
+		t.Fatalf("expected transactions 1, 2 and 3, got %+v", entries)
+	}
+	if body, _ := sp.Read(entries[0]); string(body) != `{"user":"root"}` {
+		t.Errorf("expected the replaced payload, got %s", body)
+	}
+}
+
+func TestReject(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sp.Add(KindTransaction, "1", []byte("{}"))
+
+	entries, _ := sp.Entries()
+	if err := sp.Reject(entries[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stats, _ := sp.Stats(); stats.Count != 0 {
+		t.Errorf("expected the rejected payload out of the spool, got %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(dir, RejectedDir, filepath.Base(entries[0].path))); err != nil {
+		t.Errorf("expected the rejected payload in %s: %v", RejectedDir, err)
+	}
+
+	// Spooling the same payload again adds a new entry
+	if err := sp.Add(KindTransaction, "1", []byte("{}")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries, _ := sp.Entries(); len(entries) != 1 {
+		t.Errorf("expected the payload spooled again, got %+v", entries)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/go-resty/resty/v2"
@@ -35,6 +38,72 @@ func DateConversion(data string) (string, error) {
 	return formattedDate, nil
 }
 
+// FormatTimestamp converts a Unix timestamp to the same RFC3339 format, in
+// local time, returned by DateConversion.
+func FormatTimestamp(timestamp int64) string {
+	return time.Unix(timestamp, 0).Format("2006-01-02T15:04:05Z07:00")
+}
+
+// UserDisplayName renders a user ID the way DNF does in 'dnf history info',
+// as "Full Name <login>", the full name being the GECOS field up to the first
+// ';'. Unset login UIDs are shown as "System <unset>" and unknown UIDs are
+// returned as a plain number.
+func UserDisplayName(uid int64) string {
+	// loginuid is -1 (0xFFFFFFFF) on newer kernels and INT_MAX on older ones
+	if uid < 0 || uid == 0xFFFFFFFF || uid == 0x7FFFFFFF {
+		return "System <unset>"
+	}
+
+	id := strconv.FormatInt(uid, 10)
+	if name, ok := userNames.Load(id); ok {
+		return name.(string)
+	}
+
+	name := id
+	if entry, ok := lookupPasswd(id); ok {
+		fields := strings.Split(entry, ":")
+		fullName, _, _ := strings.Cut(fields[4], ";")
+		name = fmt.Sprintf("%s <%s>", fullName, fields[0])
+	}
+	userNames.Store(id, name)
+	return name
+}
+
+// userNames caches the names returned by UserDisplayName, by user ID.
+var userNames sync.Map
+
+// passwdFile is the local user database read by lookupPasswd.
+var passwdFile = "/etc/passwd"
+
+// lookupPasswd returns the passwd entry of a user ID. The agent is built
+// without cgo, so the standard library only reads /etc/passwd: users of NSS
+// services such as LDAP or SSSD are looked up with getent instead.
+func lookupPasswd(uid string) (string, bool) {
+	if data, err := os.ReadFile(passwdFile); err == nil {
+		if entry, ok := findPasswdEntry(string(data), uid); ok {
+			return entry, true
+		}
+	}
+
+	out, err := exec.Command("getent", "passwd", uid).Output()
+	if err != nil {
+		return "", false
+	}
+	return findPasswdEntry(string(out), uid)
+}
+
+// findPasswdEntry returns the line of a passwd database with the given user
+// ID.
+func findPasswdEntry(data, uid string) (string, bool) {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) >= 7 && fields[2] == uid {
+			return line, true
+		}
+	}
+	return "", false
+}
+
 // GetMachineId retrieves the unique machine identifier from the '/etc/machine-id' file.
 // This identifier is typically used to distinguish the host machine in a network.
 //
@@ -114,18 +183,21 @@ func SplitPackageName(packageName string) (name, version, release, epoch, arch s
 	return
 }
 
-// reVersionID matches VERSION_ID in /etc/os-release to determine the OS major version
-var reVersionID = regexp.MustCompile(`VERSION_ID="([1-9][0-9]?)(?:\.[0-9]+)?"`)
+// reVersionID matches VERSION_ID in /etc/os-release to determine the OS major version.
+// Fedora does not quote the value, so the quotes are optional.
+var reVersionID = regexp.MustCompile(`VERSION_ID="?([1-9][0-9]?)(?:\.[0-9]+)?"?`)
 
-// PackageBinary determines and verifies the appropriate package manager binary (yum or dnf)
+// PackageBinary determines and verifies the appropriate package manager binary (yum, dnf or dnf5)
 // based on the Linux distribution version. It reads /etc/os-release to check if the system
-// is running RHEL/CentOS 8 or 9, in which case it selects 'dnf' instead of the default 'yum'.
+// is running RHEL/CentOS 8 or later, in which case it selects 'dnf' instead of the default 'yum'.
+// If 'dnf5' is installed and 'dnf' is missing or is a link to it, as on Fedora 41+ and RHEL 10,
+// 'dnf5' is selected instead.
 //
 // The function also verifies if the selected package manager is installed in the system.
 // If the binary is not found, it exits with an error message.
 //
 // Returns:
-//   - string: The name of the package manager binary ("yum" or "dnf")
+//   - string: The name of the package manager binary ("yum", "dnf" or "dnf5")
 //
 // The function will exit with status code 1 if the required package manager is not installed.
 func PackageBinary() string {
@@ -145,6 +217,10 @@ func PackageBinary() string {
 		}
 	}
 
+	if IsDNF5() {
+		binary = "dnf5"
+	}
+
 	if !binaryInstalled(binary) {
 		color.Red("ERROR: %s is not installed. Exiting.", binary)
 		os.Exit(1)
@@ -153,6 +229,52 @@ func PackageBinary() string {
 	return binary
 }
 
+// IsDNF5 reports whether dnf5 is the system package manager, that is, if it
+// is installed and 'dnf' is either missing or a symbolic link to it.
+func IsDNF5() bool {
+	if !binaryInstalled("dnf5") {
+		return false
+	}
+
+	dnf, err := exec.LookPath("dnf")
+	if err != nil {
+		return true
+	}
+
+	resolved, err := filepath.EvalSymlinks(dnf)
+	if err != nil {
+		return false
+	}
+
+	return filepath.Base(resolved) == "dnf5"
+}
+
+// SetCLocale configures a command to run with the C locale, replacing any
+// LANG, LANGUAGE or LC_* variable inherited from the environment. Package
+// manager output is parsed by field labels and date formats, which are
+// translated under other locales, so every package manager invocation must
+// use it.
+//
+// Parameters:
+//   - cmd: An exec.Cmd instance to configure before it is started
+func SetCLocale(cmd *exec.Cmd) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	filtered := make([]string, 0, len(env)+2)
+	for _, variable := range env {
+		name, _, _ := strings.Cut(variable, "=")
+		if name == "LANG" || name == "LANGUAGE" || strings.HasPrefix(name, "LC_") {
+			continue
+		}
+		filtered = append(filtered, variable)
+	}
+
+	cmd.Env = append(filtered, "LANG=C", "LC_ALL=C")
+}
+
 // binaryInstalled checks if a binary is installed in the system.
 // It takes a binary name as input and returns true if the binary is found in the system PATH,
 // false otherwise.
@@ -1,6 +1,9 @@
 package util
 
 import (
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-resty/resty/v2"
@@ -94,3 +97,68 @@ func TestSetAuthentication_APIKeyPreferredOverBasicAuth(t *testing.T) {
 	// (We can't verify the Authorization header directly, but we know the function
 	// returns early when API key is set, so basic auth is never configured)
 }
+
+func TestSplitPackageName(t *testing.T) {
+	tests := []struct {
+		input                               string
+		name, version, release, epoch, arch string
+	}{
+		{"vim-enhanced-8.2.2637-20.el9.x86_64", "vim-enhanced", "8.2.2637", "20.el9", "", "x86_64"},
+		{"openssl-1:3.0.7-27.el9.x86_64", "openssl", "1:3.0.7", "27.el9", "1", "x86_64"},
+		{"tzdata-2024a-1.el9.noarch.rpm", "tzdata", "2024a", "1.el9", "", "noarch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			name, version, release, epoch, arch := SplitPackageName(tt.input)
+			if name != tt.name || version != tt.version || release != tt.release || epoch != tt.epoch || arch != tt.arch {
+				t.Errorf("SplitPackageName(%q) = (%q, %q, %q, %q, %q), want (%q, %q, %q, %q, %q)",
+					tt.input, name, version, release, epoch, arch,
+					tt.name, tt.version, tt.release, tt.epoch, tt.arch)
+			}
+		})
+	}
+}
+
+func TestSetCLocale(t *testing.T) {
+	cmd := exec.Command("true")
+	cmd.Env = []string{"PATH=/usr/bin", "LANG=pt_BR.UTF-8", "LANGUAGE=pt_BR", "LC_TIME=de_DE.UTF-8"}
+
+	SetCLocale(cmd)
+
+	want := []string{"PATH=/usr/bin", "LANG=C", "LC_ALL=C"}
+	if len(cmd.Env) != len(want) {
+		t.Fatalf("Env = %v, want %v", cmd.Env, want)
+	}
+	for i := range want {
+		if cmd.Env[i] != want[i] {
+			t.Errorf("Env[%d] = %q, want %q", i, cmd.Env[i], want[i])
+		}
+	}
+}
+
+func TestUserDisplayName(t *testing.T) {
+	passwd := filepath.Join(t.TempDir(), "passwd")
+	data := "root:x:0:0:root:/root:/bin/bash\n" +
+		"jdoe:x:61001:61001:John Doe,Room 1,555-1234;Ops:/home/jdoe:/bin/bash\n"
+	if err := os.WriteFile(passwd, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(file string) { passwdFile = file }(passwdFile)
+	passwdFile = passwd
+
+	tests := []struct {
+		uid  int64
+		want string
+	}{
+		{0, "root <root>"},
+		{61001, "John Doe,Room 1,555-1234 <jdoe>"},
+		{-1, "System <unset>"},
+		{4294967295, "System <unset>"},
+	}
+	for _, tt := range tests {
+		if got := UserDisplayName(tt.uid); got != tt.want {
+			t.Errorf("UserDisplayName(%d) = %q, want %q", tt.uid, got, tt.want)
+		}
+	}
+}
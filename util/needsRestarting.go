@@ -20,6 +20,7 @@ import (
 // no restart is needed.
 func NeedsRestarting() (bool, string) {
 	cmd := exec.Command(PackageBinary(), "needs-restarting", "-r")
+	SetCLocale(cmd)
 
 	var stdout bytes.Buffer
 	cmd.Stdout = &stdout
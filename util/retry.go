@@ -0,0 +1,178 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-resty/resty/v2"
+	"github.com/spf13/viper"
+)
+
+// Defaults of the retry policy, used when the server.retry settings are not set.
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+)
+
+// defaultRetryStatusCodes are the responses worth retrying: the server asked
+// to slow down, or a proxy in front of it could not reach it.
+var defaultRetryStatusCodes = []int{429, 502, 503, 504}
+
+// maxRetryAfter caps the delay a server can ask for with Retry-After, so that
+// a misconfigured server cannot stall the agent for hours.
+const maxRetryAfter = 5 * time.Minute
+
+// serverTimeout is the timeout of a single request to the server.
+const serverTimeout = 30 * time.Second
+
+// RetryPolicy tells how requests to the server are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a request is sent, including the
+	// first one. 1 disables retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry. It doubles with each
+	// retry, up to MaxBackoff, and a random jitter is added to it.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// StatusCodes are the response status codes that are retried. Requests
+	// that fail without a response, such as a refused connection, are always
+	// retried.
+	StatusCodes []int
+}
+
+// RetryPolicyFromConfig returns the retry policy set in the server.retry
+// settings, falling back to the defaults for the ones not set.
+func RetryPolicyFromConfig() RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:    defaultRetryMaxAttempts,
+		InitialBackoff: defaultRetryInitialBackoff,
+		MaxBackoff:     defaultRetryMaxBackoff,
+		StatusCodes:    defaultRetryStatusCodes,
+	}
+
+	if viper.IsSet("server.retry.max_attempts") {
+		policy.MaxAttempts = max(viper.GetInt("server.retry.max_attempts"), 1)
+	}
+	if viper.IsSet("server.retry.initial_backoff") {
+		policy.InitialBackoff = max(viper.GetDuration("server.retry.initial_backoff"), 0)
+	}
+	if viper.IsSet("server.retry.max_backoff") {
+		policy.MaxBackoff = max(viper.GetDuration("server.retry.max_backoff"), policy.InitialBackoff)
+	}
+	if viper.IsSet("server.retry.status_codes") {
+		policy.StatusCodes = viper.GetIntSlice("server.retry.status_codes")
+	}
+
+	return policy
+}
+
+// NewServerClient returns a client for the txlog server, with the request
+// timeout and the retry policy set in the configuration.
+func NewServerClient() *resty.Client {
+	client := resty.New()
+	client.SetTimeout(serverTimeout)
+	RetryPolicyFromConfig().Apply(client)
+	return client
+}
+
+// Apply sets the retry policy on a client. Each retry is logged to stderr,
+// with the reason and the delay before it.
+func (p RetryPolicy) Apply(client *resty.Client) {
+	// Retries are logged below, resty would log each failed attempt again
+	client.SetLogger(quietLogger{})
+	client.SetRetryCount(max(p.MaxAttempts, 1) - 1)
+	// The delay is computed by retryDelay; resty only enforces the bounds
+	client.SetRetryWaitTime(0)
+	client.SetRetryMaxWaitTime(max(p.MaxBackoff, maxRetryAfter))
+
+	client.AddRetryCondition(func(response *resty.Response, err error) bool {
+		return p.retryable(response, err)
+	})
+
+	client.SetRetryAfter(func(_ *resty.Client, response *resty.Response) (time.Duration, error) {
+		attempt := response.Request.Attempt
+		delay := p.retryDelay(attempt, response)
+
+		reason := "no response"
+		if response.RawResponse != nil {
+			reason = response.Status()
+		}
+		fmt.Fprintln(os.Stderr, color.YellowString("   ↻ %s %s failed (%s), retrying in %s (attempt %d of %d)",
+			response.Request.Method, response.Request.URL, reason, delay.Round(time.Millisecond), attempt+1, p.MaxAttempts))
+
+		return delay, nil
+	})
+}
+
+// retryable reports whether a request that got response and err is retried.
+func (p RetryPolicy) retryable(response *resty.Response, err error) bool {
+	if err != nil {
+		// Errors without a response are raised before the request is sent,
+		// such as a body that cannot be encoded, and would fail again
+		return response != nil && !errors.Is(err, context.Canceled)
+	}
+	return response != nil && slices.Contains(p.StatusCodes, response.StatusCode())
+}
+
+// retryDelay returns the delay before retrying a request after attempt
+// failed: an exponential backoff with jitter, or the delay asked by the
+// server with Retry-After when longer.
+func (p RetryPolicy) retryDelay(attempt int, response *resty.Response) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, p.MaxBackoff)
+
+	// "Equal jitter": half of the delay is fixed, the other half random, so
+	// that agents started by the same timer do not retry in lockstep
+	delay := backoff/2 + time.Duration(rand.Int64N(int64(backoff/2)+1))
+
+	if response != nil && response.RawResponse != nil {
+		if retryAfter, ok := parseRetryAfter(response.Header().Get("Retry-After"), time.Now()); ok {
+			delay = max(delay, min(retryAfter, maxRetryAfter))
+		}
+	}
+
+	// resty falls back to its own backoff on a zero delay
+	return max(delay, time.Nanosecond)
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either a number
+// of seconds or an HTTP date, into the delay it asks for.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+
+	return 0, false
+}
+
+// quietLogger discards the messages logged by resty.
+type quietLogger struct{}
+
+func (quietLogger) Errorf(string, ...interface{}) {}
+func (quietLogger) Warnf(string, ...interface{})  {}
+func (quietLogger) Debugf(string, ...interface{}) {}
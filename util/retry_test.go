@@ -0,0 +1,154 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestRetryPolicyFromConfig_Defaults(t *testing.T) {
+	viper.Reset()
+
+	policy := RetryPolicyFromConfig()
+
+	if policy.MaxAttempts != 3 || policy.InitialBackoff != time.Second || policy.MaxBackoff != 30*time.Second {
+		t.Errorf("unexpected default policy: %+v", policy)
+	}
+	if !reflect.DeepEqual(policy.StatusCodes, []int{429, 502, 503, 504}) {
+		t.Errorf("unexpected default status codes: %v", policy.StatusCodes)
+	}
+}
+
+func TestRetryPolicyFromConfig(t *testing.T) {
+	viper.Reset()
+	viper.Set("server.retry.max_attempts", 0)
+	viper.Set("server.retry.initial_backoff", "500ms")
+	viper.Set("server.retry.max_backoff", "10s")
+	viper.Set("server.retry.status_codes", []int{503})
+	defer viper.Reset()
+
+	policy := RetryPolicyFromConfig()
+
+	if policy.MaxAttempts != 1 {
+		t.Errorf("expected max attempts to be at least 1, got %d", policy.MaxAttempts)
+	}
+	if policy.InitialBackoff != 500*time.Millisecond || policy.MaxBackoff != 10*time.Second {
+		t.Errorf("unexpected backoff: %+v", policy)
+	}
+	if !reflect.DeepEqual(policy.StatusCodes, []int{503}) {
+		t.Errorf("unexpected status codes: %v", policy.StatusCodes)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 4 * time.Second}
+
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{10, 2 * time.Second, 4 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			delay := policy.retryDelay(tt.attempt, nil)
+			if delay < tt.min || delay > tt.max {
+				t.Errorf("attempt %d: expected delay between %v and %v, got %v", tt.attempt, tt.min, tt.max, delay)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"Wed, 01 Jan 2025 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 01 Jan 2025 11:00:00 GMT", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		delay, ok := parseRetryAfter(tt.value, now)
+		if delay != tt.expected || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; expected %v, %v", tt.value, delay, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestNewServerClient_Retries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.retry.initial_backoff", "1ms")
+	defer viper.Reset()
+
+	response, err := NewServerClient().R().Post(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.StatusCode() != http.StatusOK || requests != 3 {
+		t.Errorf("expected success on the third attempt, got status %d after %d requests", response.StatusCode(), requests)
+	}
+}
+
+func TestNewServerClient_GivesUp(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	viper.Reset()
+	viper.Set("server.retry.max_attempts", 2)
+	viper.Set("server.retry.initial_backoff", "1ms")
+	defer viper.Reset()
+
+	client := NewServerClient()
+
+	response, err := client.R().Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if response.StatusCode() != http.StatusServiceUnavailable || requests != 2 {
+		t.Errorf("expected to give up after 2 attempts, got status %d after %d requests", response.StatusCode(), requests)
+	}
+
+	requests = 0
+	response, _ = client.R().Get(server.URL + "/bad")
+	if response.StatusCode() != http.StatusBadRequest || requests != 1 {
+		t.Errorf("expected no retry on status 400, got %d requests", requests)
+	}
+}